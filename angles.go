@@ -0,0 +1,134 @@
+package gohokuyolidar
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// AngularScan is a scan annotated with the bearings of its points.
+type AngularScan struct {
+	Timestamp   int       // sensor time in milliseconds
+	Angles      []float64 // bearing of each point in radians
+	Distances   []int     // millimeters or error codes
+	Resolution  float64   // angle covered by one point in radians
+	MinDistance int       // of the sensor, shorter distances are error codes
+}
+
+// Points converts the scan to cartesian points in the sensor frame. Points
+// without a valid return become zero vectors like in DataToCartesian.
+func (s AngularScan) Points() []mgl64.Vec2 {
+	points := make([]mgl64.Vec2, len(s.Distances))
+	for i, d := range s.Distances {
+		if d < s.MinDistance || d <= 0 || i >= len(s.Angles) {
+			continue
+		}
+		points[i] = mgl64.Vec2{float64(d) * math.Cos(s.Angles[i]), float64(d) * math.Sin(s.Angles[i])}
+	}
+	return points
+}
+
+// StepAngle is the angle between two steps in radians.
+func (s Spec) StepAngle() float64 {
+	return 2 * math.Pi / float64(s.Resolution)
+}
+
+// FieldOfView returns the bearings of the first and last measurable steps
+// in radians.
+func (s Spec) FieldOfView() (float64, float64) {
+	return s.StepToRadians(s.MinStep), s.StepToRadians(s.MaxStep)
+}
+
+// WindowConfig computes the scan covering the bearings from start to end
+// in radians with points about resolution radians apart. The resolution
+// is rounded to a whole number of steps, a resolution of zero uses every
+// step. The window must lie in the field of view.
+func (s Spec) WindowConfig(start, end, resolution float64) (ScanConfig, error) {
+	min, max := s.FieldOfView()
+	// tolerate the rounding of bearings taken from StepToRadians
+	eps := s.StepAngle() / 2
+	if end < start {
+		return ScanConfig{}, fmt.Errorf("%w: window ends at %v before its start %v", ErrInvalidScanConfig, end, start)
+	}
+	if start < min-eps || end > max+eps {
+		return ScanConfig{}, fmt.Errorf("%w: window %v to %v exceeds the field of view %v to %v",
+			ErrInvalidScanConfig, start, end, min, max)
+	}
+	c := ScanConfigForAngles(s, start, end)
+	c.ClusterCount = int(math.Round(resolution / s.StepAngle()))
+	if c.ClusterCount < 1 {
+		c.ClusterCount = 1
+	}
+	if c.ClusterCount > 99 {
+		return ScanConfig{}, fmt.Errorf("%w: resolution %v is coarser than 99 steps", ErrInvalidScanConfig, resolution)
+	}
+	return c, c.Validate(s)
+}
+
+// Angles returns the bearing of every point of a scan in radians. A
+// clustered point lies in the middle of its steps, the last cluster may be
+// shorter than the others.
+func (c ScanConfig) Angles(spec Spec) []float64 {
+	angles := []float64{}
+	n := c.cluster()
+	for first := c.StartStep; first <= c.EndStep; first += n {
+		last := first + n - 1
+		if last > c.EndStep {
+			last = c.EndStep
+		}
+		angles = append(angles, spec.StepToRadians(first)+float64(last-first)/2*spec.StepAngle())
+	}
+	return angles
+}
+
+// ScanWindow takes a single scan with GD of the bearings from start to end
+// in radians, with points about resolution radians apart. The laser must
+// be on.
+func (h *HokuyoLidar) ScanWindow(start, end, resolution float64) (AngularScan, error) {
+	h.lock()
+	defer h.unlock()
+	c, err := h.spec.WindowConfig(start, end, resolution)
+	if err != nil {
+		return AngularScan{}, err
+	}
+	if err := h.requestScan(c); err != nil {
+		return AngularScan{}, err
+	}
+	return h.getAngularScan("ScanWindow")
+}
+
+// StreamWindow starts an MD stream of the bearings from start to end in
+// radians, with points about resolution radians apart. Read the scans
+// with GetAngularScan.
+func (h *HokuyoLidar) StreamWindow(start, end, resolution float64) error {
+	h.lock()
+	defer h.unlock()
+	c, err := h.spec.WindowConfig(start, end, resolution)
+	if err != nil {
+		return err
+	}
+	return h.startStream(c)
+}
+
+// GetAngularScan reads the next scan of the current request like
+// GetDistance and annotates it with the bearings of its points.
+func (h *HokuyoLidar) GetAngularScan() (AngularScan, error) {
+	h.lock()
+	defer h.unlock()
+	return h.getAngularScan("GetAngularScan")
+}
+
+func (h *HokuyoLidar) getAngularScan(command string) (AngularScan, error) {
+	distances, timestamp, err := h.getDistance(command)
+	if err != nil {
+		return AngularScan{}, err
+	}
+	return AngularScan{
+		Timestamp:   timestamp,
+		Angles:      h.scan.Angles(h.spec),
+		Distances:   distances,
+		Resolution:  float64(h.scan.cluster()) * h.spec.StepAngle(),
+		MinDistance: h.spec.MinDistance,
+	}, nil
+}
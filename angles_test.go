@@ -0,0 +1,128 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestWindowConfig(t *testing.T) {
+	spec := DefaultSpec()
+	c, err := spec.WindowConfig(-math.Pi/2, math.Pi/2, 1*math.Pi/180)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one degree is 2.84 steps of the URG-04LX
+	if c.StartStep != AFRT-256 || c.EndStep != AFRT+256 || c.ClusterCount != 3 {
+		t.Fatalf("Unexpected configuration %+v\n", c)
+	}
+
+	min, max := spec.FieldOfView()
+	if c, err := spec.WindowConfig(min, max, 0); err != nil || c.StartStep != AMIN || c.EndStep != AMAX || c.ClusterCount != 1 {
+		t.Fatalf("Expected the full field of view, got %+v, %v\n", c, err)
+	}
+	if _, err := spec.WindowConfig(-math.Pi, 0, 0); !errors.Is(err, ErrInvalidScanConfig) {
+		t.Fatalf("Expected a window outside the field of view to fail, got %v\n", err)
+	}
+	if _, err := spec.WindowConfig(0, 1, math.Pi); !errors.Is(err, ErrInvalidScanConfig) {
+		t.Fatalf("Expected a resolution beyond 99 steps to fail, got %v\n", err)
+	}
+}
+
+func TestScanConfigAngles(t *testing.T) {
+	spec := DefaultSpec()
+	c := ScanConfig{StartStep: AFRT, EndStep: AFRT + 7, ClusterCount: 3}
+	angles := c.Angles(spec)
+	step := spec.StepAngle()
+	want := []float64{step, 4 * step, 6.5 * step}
+	if len(angles) != len(want) {
+		t.Fatalf("Expected %d angles, got %v\n", len(want), angles)
+	}
+	for i := range want {
+		if math.Abs(angles[i]-want[i]) > 1e-12 {
+			t.Fatalf("Expected angle %d at %v, got %v\n", i, want[i], angles[i])
+		}
+	}
+
+	// the driver reports the same angles for full clusters
+	h := NewHokuyoLidar("", 0)
+	h.scan = c
+	if a := h.StartAngle() + h.AngularStep(); math.Abs(a-angles[1]) > 1e-12 {
+		t.Fatalf("Expected the second point at %v, got %v\n", angles[1], a)
+	}
+}
+
+func TestScanWindowState(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	h.state = StateIdle
+	if _, err := h.ScanWindow(-0.5, 0.5, 0); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected a single scan to need the laser, got %v\n", err)
+	}
+	if _, err := h.GetAngularScan(); !errors.Is(err, ErrNoScan) {
+		t.Fatalf("Expected ErrNoScan, got %v\n", err)
+	}
+}
+
+func TestScanWindow(t *testing.T) {
+	spec := DefaultSpec()
+	c, err := spec.WindowConfig(-0.5, 0.5, 2*spec.StepAngle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	distances := []int{}
+	for i := 0; i < c.points(); i++ {
+		distances = append(distances, 500+i*11)
+	}
+	command := fmt.Sprintf("GD%04d%04d%02d\n", c.StartStep, c.EndStep, c.ClusterCount)
+	h, port := connectedLidar(gdReply(command, 42, distances, 3))
+
+	scan, err := h.ScanWindow(-0.5, 0.5, 2*spec.StepAngle())
+	if err != nil {
+		t.Fatalf("Expected the window to be scanned, got %v\n", err)
+	}
+	if port.written.String() != command {
+		t.Fatalf("Expected the command %q, got %q\n", command, port.written.String())
+	}
+	if scan.Timestamp != 42 || len(scan.Distances) != len(distances) || len(scan.Angles) != len(distances) {
+		t.Fatalf("Expected %d points at 42, got %d distances and %d angles at %v\n",
+			len(distances), len(scan.Distances), len(scan.Angles), scan.Timestamp)
+	}
+	for i, d := range scan.Distances {
+		if d != distances[i] {
+			t.Fatalf("Expected distance %d at %d, got %d\n", distances[i], i, d)
+		}
+		first := c.StartStep + i*c.ClusterCount
+		last := first + c.ClusterCount - 1
+		if last > c.EndStep {
+			last = c.EndStep
+		}
+		expected := spec.StepToRadians(first) + float64(last-first)/2*spec.StepAngle()
+		if math.Abs(scan.Angles[i]-expected) > 1e-12 {
+			t.Fatalf("Expected point %d at %v, got %v\n", i, expected, scan.Angles[i])
+		}
+	}
+	if math.Abs(scan.Angles[0]+0.5) > spec.StepAngle() || math.Abs(scan.Angles[len(scan.Angles)-1]-0.5) > spec.StepAngle() {
+		t.Fatalf("Expected the window to span -0.5 to 0.5, got %v to %v\n", scan.Angles[0], scan.Angles[len(scan.Angles)-1])
+	}
+	if math.Abs(scan.Resolution-2*spec.StepAngle()) > 1e-12 {
+		t.Fatalf("Expected a resolution of two steps, got %v\n", scan.Resolution)
+	}
+	if scan.MinDistance != spec.MinDistance {
+		t.Fatalf("Expected the minimum distance of the sensor, got %v\n", scan.MinDistance)
+	}
+}
+
+func TestAngularScanPoints(t *testing.T) {
+	// a sensor with a longer minimum distance than the URG-04LX
+	scan := AngularScan{Angles: []float64{0, math.Pi / 2, 0}, Distances: []int{30, 100, 60}, MinDistance: 60}
+	points := scan.Points()
+	if points[0] != (mgl64.Vec2{}) {
+		t.Fatalf("Expected a distance below the minimum to be dropped, got %v\n", points[0])
+	}
+	if points[1].Sub(mgl64.Vec2{0, 100}).Len() > 1e-9 || points[2] != (mgl64.Vec2{60, 0}) {
+		t.Fatalf("Expected the valid points, got %v\n", points)
+	}
+}
@@ -0,0 +1,196 @@
+// Package background learns the range profile of a fixed mounted sensor and
+// reports the angular ranges where later scans deviate from it. A Model
+// returns the intrusions of every scan, a Detector turns them into events
+// when intrusions begin and end.
+package background
+
+import (
+	"errors"
+	"math"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+)
+
+// Config holds the parameters of the background model.
+type Config struct {
+	// TrainingScans is the number of scans averaged into the background.
+	TrainingScans int
+	// Threshold is the smallest deviation in millimeters that is flagged.
+	Threshold float64
+	// Sigmas flags deviations larger than this many standard deviations
+	// of a step, when that is larger than Threshold.
+	Sigmas float64
+	// MinSteps is the smallest number of neighbouring deviating steps that
+	// form an intrusion.
+	MinSteps int
+	// MaxGap joins intrusions separated by at most this many steps.
+	MaxGap int
+	// FlagFarther also flags steps that became farther than the background,
+	// e.g. an opened door. By default only closer objects are intrusions.
+	FlagFarther bool
+	// MinRange is the smallest valid distance, smaller values are error codes.
+	MinRange int
+	// StartAngle and Step give the bearing of the steps in radians. Set
+	// them from StartAngle and AngularStep of the lidar for scans other
+	// than the full field of view of the URG-04LX.
+	StartAngle float64
+	Step       float64
+}
+
+// DefaultConfig returns parameters suited for full scans of the URG-04LX.
+func DefaultConfig() Config {
+	spec := lidar.DefaultSpec()
+	return Config{
+		TrainingScans: 50,
+		Threshold:     100.0,
+		Sigmas:        4.0,
+		MinSteps:      3,
+		MaxGap:        2,
+		MinRange:      spec.MinDistance,
+		StartAngle:    spec.StepToRadians(spec.MinStep),
+		Step:          spec.StepAngle(),
+	}
+}
+
+// Intrusion is an angular range in which the scan deviates from the
+// background.
+type Intrusion struct {
+	StartStep   int     // first deviating step
+	EndStep     int     // last deviating step
+	StartAngle  float64 // bearing of the first step in radians
+	EndAngle    float64 // bearing of the last step in radians
+	MinDistance int     // closest valid distance inside the range
+	Deviation   float64 // largest absolute deviation in millimeters
+}
+
+// Model is the learned background range profile.
+type Model struct {
+	config Config
+	count  []int     // valid samples per step
+	mean   []float64 // millimeters
+	m2     []float64 // sum of squared differences to the mean
+	scans  int
+}
+
+// NewModel creates an untrained model.
+func NewModel(config Config) *Model {
+	return &Model{config: config}
+}
+
+// Train adds a scan to the background and reports whether the training
+// window is complete. Scans after the window are ignored.
+func (m *Model) Train(distances []int) (bool, error) {
+	if m.Trained() {
+		return true, nil
+	}
+	if m.mean == nil {
+		m.count = make([]int, len(distances))
+		m.mean = make([]float64, len(distances))
+		m.m2 = make([]float64, len(distances))
+	} else if len(distances) != len(m.mean) {
+		return false, errors.New("Scan size differs from the training scans")
+	}
+	for i, d := range distances {
+		if d < m.config.MinRange {
+			continue
+		}
+		// Welford's running mean and variance
+		m.count[i]++
+		delta := float64(d) - m.mean[i]
+		m.mean[i] += delta / float64(m.count[i])
+		m.m2[i] += delta * (float64(d) - m.mean[i])
+	}
+	m.scans++
+	return m.Trained(), nil
+}
+
+// Trained reports whether the training window is complete.
+func (m *Model) Trained() bool {
+	return m.scans >= m.config.TrainingScans
+}
+
+// Reset forgets the background so that it can be trained again.
+func (m *Model) Reset() {
+	m.count, m.mean, m.m2, m.scans = nil, nil, nil, 0
+}
+
+// Mean returns the background distance of a step and whether the step had
+// enough valid returns during training.
+func (m *Model) Mean(step int) (float64, bool) {
+	if step < 0 || step >= len(m.mean) || m.count[step] < m.scans/2 || m.count[step] == 0 {
+		return 0, false
+	}
+	return m.mean[step], true
+}
+
+// StdDev returns the standard deviation of the background distance of a step.
+func (m *Model) StdDev(step int) float64 {
+	if step < 0 || step >= len(m.mean) || m.count[step] < 2 {
+		return 0
+	}
+	return math.Sqrt(m.m2[step] / float64(m.count[step]-1))
+}
+
+// Deviates reports whether the distance of a step deviates from the
+// background and by how much.
+func (m *Model) Deviates(step, distance int) (bool, float64) {
+	if distance < m.config.MinRange {
+		return false, 0
+	}
+	mean, ok := m.Mean(step)
+	if !ok {
+		// no background return, anything seen there is new
+		return true, math.Inf(1)
+	}
+	dev := float64(distance) - mean
+	limit := math.Max(m.config.Threshold, m.config.Sigmas*m.StdDev(step))
+	if dev < -limit || (m.config.FlagFarther && dev > limit) {
+		return true, math.Abs(dev)
+	}
+	return false, math.Abs(dev)
+}
+
+// Detect compares a scan with the background and returns the intrusions.
+func (m *Model) Detect(distances []int) ([]Intrusion, error) {
+	if !m.Trained() {
+		return nil, errors.New("Background model is not trained")
+	}
+	if len(distances) != len(m.mean) {
+		return nil, errors.New("Scan size differs from the training scans")
+	}
+	intrusions := []Intrusion{}
+	var current *Intrusion
+	steps, gap := 0, 0
+	flush := func() {
+		if current != nil && steps >= m.config.MinSteps {
+			intrusions = append(intrusions, *current)
+		}
+		current, steps, gap = nil, 0, 0
+	}
+	for i, d := range distances {
+		deviates, dev := m.Deviates(i, d)
+		if !deviates {
+			if current != nil {
+				gap++
+				if gap > m.config.MaxGap {
+					flush()
+				}
+			}
+			continue
+		}
+		if current == nil {
+			current = &Intrusion{StartStep: i, MinDistance: d}
+		}
+		gap = 0
+		steps++
+		current.EndStep = i
+		current.StartAngle = m.config.StartAngle + float64(current.StartStep)*m.config.Step
+		current.EndAngle = m.config.StartAngle + float64(i)*m.config.Step
+		if d < current.MinDistance {
+			current.MinDistance = d
+		}
+		current.Deviation = math.Max(current.Deviation, dev)
+	}
+	flush()
+	return intrusions, nil
+}
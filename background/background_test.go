@@ -0,0 +1,105 @@
+package background
+
+import (
+	"math"
+	"testing"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+)
+
+func profile() []int {
+	d := make([]int, 100)
+	for i := range d {
+		d[i] = 3000 + (i%3)*5
+	}
+	d[50] = 0 // never returns
+	return d
+}
+
+func TestDetect(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrainingScans = 5
+	m := NewModel(cfg)
+	for i := 0; i < 5; i++ {
+		done, err := m.Train(profile())
+		if err != nil {
+			t.Fatalf("Train failed: %v\n", err)
+		}
+		if done != (i == 4) {
+			t.Fatalf("Expected training to complete after 5 scans\n")
+		}
+	}
+
+	scan := profile()
+	intrusions, err := m.Detect(scan)
+	if err != nil || len(intrusions) != 0 {
+		t.Fatalf("Expected no intrusion on the background, got %v %v\n", intrusions, err)
+	}
+
+	for i := 20; i < 30; i++ {
+		scan[i] = 1500
+	}
+	scan[24] = 0 // dropout inside the object
+	scan[80] = 1000
+	intrusions, _ = m.Detect(scan)
+	if len(intrusions) != 1 {
+		t.Fatalf("Expected a single intrusion, got %v\n", intrusions)
+	}
+	in := intrusions[0]
+	if in.StartStep != 20 || in.EndStep != 29 || in.MinDistance != 1500 {
+		t.Fatalf("Expected an intrusion over steps 20-29 at 1500mm, got %+v\n", in)
+	}
+	if in.EndAngle <= in.StartAngle {
+		t.Fatalf("Expected an increasing angular range, got %+v\n", in)
+	}
+	spec := lidar.DefaultSpec()
+	if want := spec.StepToRadians(spec.MinStep + 20); math.Abs(in.StartAngle-want) > 1e-9 {
+		t.Fatalf("Expected the bearing of step 20 to be %v, got %v\n", want, in.StartAngle)
+	}
+}
+
+func TestDetectorEvents(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrainingScans = 1
+	m := NewModel(cfg)
+	m.Train(profile())
+	d := NewDetector(m)
+
+	withObject := func(from, to int) []int {
+		scan := profile()
+		for i := from; i <= to; i++ {
+			scan[i] = 1500
+		}
+		return scan
+	}
+	for i, c := range []struct {
+		scan []int
+		want []EventKind
+	}{
+		{profile(), nil},
+		{withObject(20, 29), []EventKind{IntrusionBegin}},
+		// the object moves by a few steps
+		{withObject(23, 32), nil},
+		{withObject(23, 32), nil},
+		{profile(), []EventKind{IntrusionEnd}},
+		{withObject(70, 75), []EventKind{IntrusionBegin}},
+		// it jumps away, one intrusion ends and another begins
+		{withObject(10, 15), []EventKind{IntrusionEnd, IntrusionBegin}},
+	} {
+		events, err := d.Update(c.scan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(c.want) {
+			t.Fatalf("Scan %d: expected %v, got %+v\n", i, c.want, events)
+		}
+		for j, e := range events {
+			if e.Kind != c.want[j] {
+				t.Fatalf("Scan %d: expected %v, got %+v\n", i, c.want, events)
+			}
+		}
+	}
+	if active := d.Active(); len(active) != 1 || active[0].StartStep != 10 {
+		t.Fatalf("Expected the intrusion at step 10 to be active, got %+v\n", active)
+	}
+}
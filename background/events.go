@@ -0,0 +1,82 @@
+package background
+
+// EventKind is the kind of an intrusion event.
+type EventKind int
+
+const (
+	// IntrusionBegin reports an intrusion that was not in the previous scan.
+	IntrusionBegin EventKind = iota
+	// IntrusionEnd reports an intrusion that is gone from the scan.
+	IntrusionEnd
+)
+
+func (k EventKind) String() string {
+	if k == IntrusionEnd {
+		return "end"
+	}
+	return "begin"
+}
+
+// Event reports an intrusion entering or leaving the monitored area.
+type Event struct {
+	Kind EventKind
+	// Intrusion is the range of the first scan for IntrusionBegin and of
+	// the last scan it was seen in for IntrusionEnd.
+	Intrusion Intrusion
+}
+
+// Detector follows the intrusions of a trained model from scan to scan. An
+// intrusion continues one of the previous scan when their step ranges
+// overlap or are at most MaxGap steps apart, so that moving objects don't
+// end and begin again on every scan.
+type Detector struct {
+	model  *Model
+	active []Intrusion
+}
+
+// NewDetector creates a detector for a trained model.
+func NewDetector(model *Model) *Detector {
+	return &Detector{model: model}
+}
+
+// Update detects the intrusions of a scan and returns the intrusions that
+// began and ended since the previous scan, ends first.
+func (d *Detector) Update(distances []int) ([]Event, error) {
+	intrusions, err := d.model.Detect(distances)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for _, prev := range d.active {
+		if !d.overlaps(prev, intrusions) {
+			events = append(events, Event{IntrusionEnd, prev})
+		}
+	}
+	for _, in := range intrusions {
+		if !d.overlaps(in, d.active) {
+			events = append(events, Event{IntrusionBegin, in})
+		}
+	}
+	d.active = intrusions
+	return events, nil
+}
+
+// Active returns the intrusions of the latest scan.
+func (d *Detector) Active() []Intrusion {
+	return append([]Intrusion{}, d.active...)
+}
+
+// Reset forgets the active intrusions without reporting their end.
+func (d *Detector) Reset() {
+	d.active = nil
+}
+
+func (d *Detector) overlaps(in Intrusion, others []Intrusion) bool {
+	gap := d.model.config.MaxGap
+	for _, o := range others {
+		if in.StartStep <= o.EndStep+gap && o.StartStep <= in.EndStep+gap {
+			return true
+		}
+	}
+	return false
+}
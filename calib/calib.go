@@ -0,0 +1,267 @@
+// Package calib estimates the mounting pose of one lidar relative to
+// another from simultaneous scans of a static scene.
+package calib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/scanmatch"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Source is a lidar delivering scans, such as a connected *HokuyoLidar
+// after MDMSCmd.
+type Source interface {
+	GetDistance() ([]int, int, error)
+	DataToCartesian(distances []int) []mgl64.Vec2
+}
+
+// Pair holds simultaneous scans of the two sensors, as returned by
+// DataToCartesian.
+type Pair struct {
+	Reference []mgl64.Vec2
+	Sensor    []mgl64.Vec2
+}
+
+// Config holds the parameters of the calibration.
+type Config struct {
+	// Search bounds the correlative search around the initial guess.
+	Search scanmatch.CorrelativeConfig
+	// Refine is used to polish the correlative result.
+	Refine scanmatch.ICPConfig
+	// InlierDistance is the largest distance in millimeters of a sensor
+	// point to the reference scan for it to count in the residuals.
+	InlierDistance float64
+}
+
+// DefaultConfig searches one meter and half a radian around the guess.
+func DefaultConfig() Config {
+	search := scanmatch.DefaultCorrelativeConfig()
+	search.WindowXY = 1000.0
+	search.WindowTheta = 0.5
+	search.Resolution = 25.0
+	return Config{
+		Search:         search,
+		Refine:         scanmatch.DefaultICPConfig(),
+		InlierDistance: 50.0,
+	}
+}
+
+// Report is the result of a calibration.
+type Report struct {
+	Pose          geom.Pose  // pose of the sensor in the reference frame
+	Covariance    mgl64.Mat3 // of (X, Y, Theta) from the residuals at Pose
+	RMS           float64    // RMS distance of the inliers in millimeters
+	Inliers       int        // sensor points within InlierDistance
+	Points        int        // valid sensor points
+	PairResiduals []float64  // RMS of the inliers of every pair
+	Converged     bool       // whether the refinement converged
+}
+
+// Overlap returns the fraction of sensor points that matched the reference.
+func (r Report) Overlap() float64 {
+	if r.Points == 0 {
+		return 0
+	}
+	return float64(r.Inliers) / float64(r.Points)
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("x=%.1fmm y=%.1fmm theta=%.4frad (%.2fdeg) sd=(%.1fmm %.1fmm %.4frad) rms=%.1fmm overlap=%.0f%% (%d/%d) converged=%v",
+		r.Pose.X, r.Pose.Y, r.Pose.Theta, r.Pose.Theta*180.0/math.Pi,
+		math.Sqrt(r.Covariance.At(0, 0)), math.Sqrt(r.Covariance.At(1, 1)), math.Sqrt(r.Covariance.At(2, 2)),
+		r.RMS, 100.0*r.Overlap(), r.Inliers, r.Points, r.Converged)
+}
+
+// Calibrate solves for the pose of the sensor in the frame of the
+// reference lidar. guess is a rough measurement of the mounting, e.g. from
+// the CAD model of the robot.
+func Calibrate(pairs []Pair, guess geom.Pose, config Config) (Report, error) {
+	if len(pairs) == 0 {
+		return Report{}, errors.New("No scan pairs to calibrate with")
+	}
+	reference := []mgl64.Vec2{}
+	sensor := []mgl64.Vec2{}
+	for _, p := range pairs {
+		reference = append(reference, p.Reference...)
+		sensor = append(sensor, p.Sensor...)
+	}
+
+	search, err := scanmatch.NewCorrelativeMatcher(reference, config.Search).Match(sensor, guess)
+	if err != nil {
+		return Report{}, fmt.Errorf("Correlative search failed: %v", err)
+	}
+	report := Report{Pose: search.Pose}
+
+	// the reference normals only make sense within one scan, so the pairs
+	// are refined individually and the results averaged
+	var sum mgl64.Vec3
+	var sin, cos float64
+	refined := 0
+	report.Converged = true
+	for _, p := range pairs {
+		res, err := scanmatch.ICP(p.Reference, p.Sensor, search.Pose, config.Refine)
+		if err != nil {
+			continue
+		}
+		refined++
+		sum = sum.Add(mgl64.Vec3{res.Pose.X, res.Pose.Y, 0})
+		sin += math.Sin(res.Pose.Theta)
+		cos += math.Cos(res.Pose.Theta)
+		report.Converged = report.Converged && res.Converged
+	}
+	if refined > 0 {
+		report.Pose = geom.Pose{
+			X:     sum[0] / float64(refined),
+			Y:     sum[1] / float64(refined),
+			Theta: math.Atan2(sin, cos),
+		}
+	} else {
+		report.Converged = false
+	}
+
+	var total float64
+	var info mgl64.Mat3
+	for _, p := range pairs {
+		sq, inliers, points, pairInfo := residuals(p, report.Pose, config.InlierDistance)
+		total += sq
+		info = info.Add(pairInfo)
+		report.Inliers += inliers
+		report.Points += points
+		rms := 0.0
+		if inliers > 0 {
+			rms = math.Sqrt(sq / float64(inliers))
+		}
+		report.PairResiduals = append(report.PairResiduals, rms)
+	}
+	if report.Inliers == 0 {
+		return report, errors.New("No overlap between the sensors at the solved pose")
+	}
+	report.RMS = math.Sqrt(total / float64(report.Inliers))
+	// the point to line residuals give the information about the pose,
+	// scaled by their variance
+	if report.Inliers > 3 && math.Abs(info.Det()) > 1e-9 {
+		report.Covariance = info.Inv().Mul(total / float64(report.Inliers-3))
+	} else {
+		report.Covariance = mgl64.Diag3(mgl64.Vec3{math.Inf(1), math.Inf(1), math.Inf(1)})
+	}
+	return report, nil
+}
+
+// residuals returns the sum of squared distances of the inliers of a pair
+// to the reference surface, the number of inliers, the number of valid
+// sensor points and the information matrix of (X, Y, Theta) at pose. The
+// surface is approximated by the segments between the closest reference
+// point and its neighbours.
+func residuals(p Pair, pose geom.Pose, maxDistance float64) (float64, int, int, mgl64.Mat3) {
+	reference := geom.Pose{}.TransformAll(p.Reference)
+	sum, inliers := 0.0, 0
+	var info mgl64.Mat3
+	moved := pose.TransformAll(p.Sensor)
+	for _, q := range moved {
+		nearest, best := -1, math.Inf(1)
+		for j, r := range reference {
+			if d := q.Sub(r).Len(); d < best {
+				nearest, best = j, d
+			}
+		}
+		if nearest < 0 {
+			continue
+		}
+		closest, normal := reference[nearest], mgl64.Vec2{}
+		for _, j := range []int{nearest - 1, nearest + 1} {
+			if j < 0 || j >= len(reference) {
+				continue
+			}
+			c, n := closestOnSegment(q, reference[nearest], reference[j])
+			if d := q.Sub(c).Len(); d <= best {
+				best, closest, normal = d, c, n
+			}
+		}
+		if best > maxDistance {
+			continue
+		}
+		sum += best * best
+		inliers++
+		if normal.Len() == 0 {
+			if d := q.Sub(closest); d.Len() > 0 {
+				normal = d.Normalize()
+			}
+		}
+		// derivative of the distance along the normal by (X, Y, Theta)
+		arm := q.Sub(pose.Vec())
+		j := mgl64.Vec3{normal[0], normal[1], normal[1]*arm[0] - normal[0]*arm[1]}
+		for a := 0; a < 3; a++ {
+			for b := 0; b < 3; b++ {
+				info.Set(a, b, info.At(a, b)+j[a]*j[b])
+			}
+		}
+	}
+	return sum, inliers, len(moved), info
+}
+
+// closestOnSegment returns the point of the segment from a to b closest to
+// p and the unit normal of the segment.
+func closestOnSegment(p, a, b mgl64.Vec2) (mgl64.Vec2, mgl64.Vec2) {
+	ab := b.Sub(a)
+	l := ab.Dot(ab)
+	if l == 0 {
+		return a, mgl64.Vec2{}
+	}
+	t := math.Max(0, math.Min(1, p.Sub(a).Dot(ab)/l))
+	return a.Add(ab.Mul(t)), mgl64.Vec2{-ab[1], ab[0]}.Normalize()
+}
+
+// MedianScan returns the per step median of several scans of a static
+// scene, which removes most of the range noise before calibrating.
+// Error codes are ignored unless every scan reported one for the step.
+func MedianScan(scans [][]int) []int {
+	if len(scans) == 0 {
+		return nil
+	}
+	out := make([]int, len(scans[0]))
+	values := make([]int, 0, len(scans))
+	for i := range out {
+		values = values[:0]
+		for _, s := range scans {
+			if i < len(s) && s[i] >= 20 {
+				values = append(values, s[i])
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sort.Ints(values)
+		out[i] = values[len(values)/2]
+	}
+	return out
+}
+
+// Collect reads scans of a static scene from both lidars in turn and
+// returns the per step medians of each as a pair. Call it for several
+// placements of the sensors or of objects in the scene to collect the
+// pairs for Calibrate.
+func Collect(reference, sensor Source, scans int) (Pair, error) {
+	if scans <= 0 {
+		return Pair{}, errors.New("Expected at least one scan per sensor")
+	}
+	sources := []Source{reference, sensor}
+	distances := make([][][]int, len(sources))
+	for n := 0; n < scans; n++ {
+		for i, src := range sources {
+			d, _, err := src.GetDistance()
+			if err != nil {
+				return Pair{}, fmt.Errorf("Failed to read scan %d of sensor %d: %v", n, i, err)
+			}
+			distances[i] = append(distances[i], d)
+		}
+	}
+	return Pair{
+		Reference: reference.DataToCartesian(MedianScan(distances[0])),
+		Sensor:    sensor.DataToCartesian(MedianScan(distances[1])),
+	}, nil
+}
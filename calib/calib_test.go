@@ -0,0 +1,136 @@
+package calib
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// view returns the points of a room with a box in it seen from pose, in
+// the frame of the sensor.
+func view(pose geom.Pose) []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for i := 0; i < 682; i++ {
+		theta := pose.Theta - 2.09 + float64(i)*2*math.Pi/1024
+		dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+		r := math.Inf(1)
+		planes := []struct{ axis, at float64 }{{0, 3000}, {0, -2500}, {1, 2000}, {1, -1800}}
+		for _, pl := range planes {
+			d := dir[int(pl.axis)]
+			if d == 0 {
+				continue
+			}
+			if t := (pl.at - pose.Vec()[int(pl.axis)]) / d; t > 0 {
+				r = math.Min(r, t)
+			}
+		}
+		// a box between x 800..1200 and y -400..0
+		for _, x := range []float64{800, 1200} {
+			if t := (x - pose.X) / dir[0]; t > 0 {
+				if y := pose.Y + t*dir[1]; y >= -400 && y <= 0 {
+					r = math.Min(r, t)
+				}
+			}
+		}
+		for _, y := range []float64{-400, 0} {
+			if t := (y - pose.Y) / dir[1]; t > 0 {
+				if x := pose.X + t*dir[0]; x >= 800 && x <= 1200 {
+					r = math.Min(r, t)
+				}
+			}
+		}
+		world := pose.Vec().Add(dir.Mul(r))
+		points = append(points, pose.Inverse().Transform(world))
+	}
+	return points
+}
+
+func TestCalibrate(t *testing.T) {
+	truth := geom.Pose{X: -150, Y: 400, Theta: 0.35}
+	pairs := []Pair{{Reference: view(geom.Pose{}), Sensor: view(truth)}}
+	report, err := Calibrate(pairs, geom.Pose{X: 0, Y: 300, Theta: 0.2}, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Calibrate failed: %v\n", err)
+	}
+	if math.Hypot(report.Pose.X-truth.X, report.Pose.Y-truth.Y) > 5 || math.Abs(report.Pose.Theta-truth.Theta) > 0.005 {
+		t.Fatalf("Expected %v, got %v\n", truth, report)
+	}
+	if report.RMS > 5 || report.Overlap() < 0.5 || len(report.PairResiduals) != 1 {
+		t.Fatalf("Unexpected residuals: %v\n", report)
+	}
+	for i := 0; i < 3; i++ {
+		if v := report.Covariance.At(i, i); !(v >= 0 && v < 1) {
+			t.Fatalf("Expected a small variance of the exact solution, got %v\n", report.Covariance)
+		}
+	}
+}
+
+func TestCovariance(t *testing.T) {
+	truth := geom.Pose{X: -150, Y: 400, Theta: 0.35}
+	// range noise of about 10mm
+	noisy := view(truth)
+	for i, p := range noisy {
+		noisy[i] = p.Mul(1 + 0.004*math.Sin(float64(i)*1.7))
+	}
+	pairs := []Pair{{Reference: view(geom.Pose{}), Sensor: noisy}}
+	report, err := Calibrate(pairs, geom.Pose{X: 0, Y: 300, Theta: 0.2}, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Calibrate failed: %v\n", err)
+	}
+	sx, sy, st := math.Sqrt(report.Covariance.At(0, 0)), math.Sqrt(report.Covariance.At(1, 1)), math.Sqrt(report.Covariance.At(2, 2))
+	if sx <= 0 || sy <= 0 || st <= 0 {
+		t.Fatalf("Expected the noise to show in the covariance, got %v\n", report.Covariance)
+	}
+	// the error of the solution is within a few standard deviations
+	if math.Abs(report.Pose.X-truth.X) > 5*sx+1 || math.Abs(report.Pose.Y-truth.Y) > 5*sy+1 ||
+		math.Abs(report.Pose.Theta-truth.Theta) > 5*st+0.001 {
+		t.Fatalf("Expected %v within the covariance, got %v\n", truth, report)
+	}
+}
+
+// source replays scans and converts step i to the point (d, i).
+type source struct {
+	scans [][]int
+}
+
+func (s *source) GetDistance() ([]int, int, error) {
+	if len(s.scans) == 0 {
+		return nil, 0, errors.New("no scan")
+	}
+	d := s.scans[0]
+	s.scans = s.scans[1:]
+	return d, 0, nil
+}
+
+func (s *source) DataToCartesian(distances []int) []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for i, d := range distances {
+		points = append(points, mgl64.Vec2{float64(d), float64(i)})
+	}
+	return points
+}
+
+func TestCollect(t *testing.T) {
+	reference := &source{[][]int{{100, 0}, {110, 0}, {90, 0}}}
+	sensor := &source{[][]int{{300, 400}, {310, 5}, {290, 410}}}
+	pair, err := Collect(reference, sensor, 3)
+	if err != nil {
+		t.Fatalf("Collect failed: %v\n", err)
+	}
+	if pair.Reference[0][0] != 100 || pair.Reference[1][0] != 0 || pair.Sensor[0][0] != 300 || pair.Sensor[1][0] != 410 {
+		t.Fatalf("Expected the medians of the scans, got %v\n", pair)
+	}
+	if _, err := Collect(&source{}, sensor, 1); err == nil {
+		t.Fatal("Expected a failed read to be reported")
+	}
+}
+
+func TestMedianScan(t *testing.T) {
+	m := MedianScan([][]int{{100, 0, 300}, {110, 0, 5}, {90, 0, 310}})
+	if m[0] != 100 || m[1] != 0 || m[2] != 310 {
+		t.Fatalf("Unexpected median %v\n", m)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+)
+
+// canvas is a character grid where each cell holds 2x4 braille dots, or a
+// single dot in ASCII mode, so that it renders on any terminal.
+type canvas struct {
+	cols, rows int
+	ascii      bool
+	dots       [][]uint8 // braille dot bits per cell
+	overlay    [][]uint8 // braille dot bits of the 'o' marks, drawn dimmed
+	marks      [][]byte  // ASCII character per cell
+	text       [][]bool  // cells showing their mark in braille mode
+}
+
+// overlayMark is drawn in the overlay layer in braille mode.
+const overlayMark = 'o'
+
+// brailleBits maps a dot position inside a cell to its braille bit.
+var brailleBits = [4][2]uint8{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func newCanvas(cols, rows int, ascii bool) *canvas {
+	c := &canvas{cols: cols, rows: rows, ascii: ascii}
+	c.dots = make([][]uint8, rows)
+	c.overlay = make([][]uint8, rows)
+	c.marks = make([][]byte, rows)
+	c.text = make([][]bool, rows)
+	for r := range c.dots {
+		c.dots[r] = make([]uint8, cols)
+		c.overlay[r] = make([]uint8, cols)
+		c.marks[r] = []byte(strings.Repeat(" ", cols))
+		c.text[r] = make([]bool, cols)
+	}
+	return c
+}
+
+// width and height return the resolution in dots.
+func (c *canvas) width() int {
+	if c.ascii {
+		return c.cols
+	}
+	return 2 * c.cols
+}
+
+func (c *canvas) height() int {
+	if c.ascii {
+		return c.rows
+	}
+	return 4 * c.rows
+}
+
+// set marks the dot at (x, y) with y growing downwards. mark is the
+// character used in ASCII mode, a stronger mark replaces a weaker one. In
+// braille mode overlayMark dots are only drawn in cells without other dots.
+func (c *canvas) set(x, y int, mark byte) {
+	if x < 0 || y < 0 || x >= c.width() || y >= c.height() {
+		return
+	}
+	if c.ascii {
+		if markRank(mark) >= markRank(c.marks[y][x]) {
+			c.marks[y][x] = mark
+		}
+		return
+	}
+	if mark == overlayMark {
+		c.overlay[y/4][x/2] |= brailleBits[y%4][x%2]
+		return
+	}
+	c.dots[y/4][x/2] |= brailleBits[y%4][x%2]
+}
+
+func markRank(b byte) int {
+	return strings.IndexByte(" .o*#@", b)
+}
+
+// line draws a line between two dots.
+func (c *canvas) line(x0, y0, x1, y1 int, mark byte) {
+	dx, dy := x1-x0, y1-y0
+	n := max(abs(dx), abs(dy))
+	if n == 0 {
+		c.set(x0, y0, mark)
+		return
+	}
+	for i := 0; i <= n; i++ {
+		c.set(x0+dx*i/n, y0+dy*i/n, mark)
+	}
+}
+
+// label writes a label at a character cell, overriding the dots.
+func (c *canvas) label(col, row int, s string) {
+	if row < 0 || row >= c.rows {
+		return
+	}
+	for i := 0; i < len(s) && col+i < c.cols; i++ {
+		if col+i < 0 {
+			continue
+		}
+		c.marks[row][col+i] = s[i]
+		c.text[row][col+i] = true
+	}
+}
+
+func (c *canvas) String() string {
+	var b strings.Builder
+	for r := 0; r < c.rows; r++ {
+		for col := 0; col < c.cols; col++ {
+			switch {
+			case c.ascii || c.text[r][col]:
+				b.WriteByte(c.marks[r][col])
+			case c.dots[r][col] != 0:
+				b.WriteRune(rune(0x2800 + int(c.dots[r][col])))
+			case c.overlay[r][col] != 0:
+				b.WriteString("\x1b[2m") // dim
+				b.WriteRune(rune(0x2800 + int(c.overlay[r][col])))
+				b.WriteString("\x1b[22m")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		if r < c.rows-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	return b.String()
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
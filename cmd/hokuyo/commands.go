@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/Dolphindalt/GoHokuyoLidar/server"
+)
+
+func runInfo(h *lidar.HokuyoLidar, args []string) error {
+	queries := []struct {
+		title string
+		query func(string) ([]string, error)
+	}{
+		{"version", h.VVCommand},
+		{"specification", h.PPCommand},
+		{"state", h.IICommand},
+	}
+	for _, q := range queries {
+		lines, err := q.query("")
+		if err != nil {
+			return fmt.Errorf("Failed to query %v: %v", q.title, err)
+		}
+		fmt.Printf("%s:\n", q.title)
+		for _, l := range lines {
+			fmt.Printf("  %s\n", strings.TrimSpace(l))
+		}
+	}
+	return nil
+}
+
+// scanFlags are the flags shared by scan and stream.
+type scanFlags struct {
+	start   *int
+	end     *int
+	cluster *int
+	two     *bool
+	format  *string
+}
+
+func newScanFlags(fs *flag.FlagSet) scanFlags {
+	return scanFlags{
+		start:   fs.Int("start", lidar.AMIN, "first step"),
+		end:     fs.Int("end", lidar.AMAX, "last step"),
+		cluster: fs.Int("cluster", 1, "number of steps grouped into one point"),
+		two:     fs.Bool("two", false, "use two character encoding (GS/MS, distances up to 4095mm)"),
+		format:  fs.String("format", "json", "output format, json or csv"),
+	}
+}
+
+// scanWriter writes scans in the selected format.
+type scanWriter func(recording.Scan) error
+
+func newScanWriter(format string, w io.Writer) (scanWriter, error) {
+	switch format {
+	case "json":
+		return recording.NewWriter(w).Write, nil
+	case "csv":
+		return func(s recording.Scan) error {
+			return writeCSV(w, s)
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown format %q", format)
+}
+
+// writeCSV writes a scan as one line: the timestamp followed by the distances.
+func writeCSV(w io.Writer, s recording.Scan) error {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(s.Timestamp))
+	for _, d := range s.Distances {
+		b.WriteByte(',')
+		b.WriteString(strconv.Itoa(d))
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func toScan(h *lidar.HokuyoLidar, distances []int, timestamp int) recording.Scan {
+	return recording.Scan{
+		Timestamp:  timestamp,
+		StartAngle: h.StartAngle(),
+		Step:       h.AngularStep(),
+		Distances:  distances,
+	}
+}
+
+func runScan(h *lidar.HokuyoLidar, args []string) error {
+	return scan(h, args, os.Stdout)
+}
+
+// scan takes a single scan with GD/GS and writes it to w.
+func scan(h *lidar.HokuyoLidar, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	sf := newScanFlags(fs)
+	fs.Parse(args)
+	write, err := newScanWriter(*sf.format, w)
+	if err != nil {
+		return err
+	}
+
+	if err := h.BMCommand(""); err != nil {
+		return fmt.Errorf("Failed to switch the laser on: %v", err)
+	}
+	defer h.QMCommand("")
+	if err := h.GDGSCommand(!*sf.two, *sf.start, *sf.end, *sf.cluster, ""); err != nil {
+		return err
+	}
+	distances, timestamp, err := h.GetDistance()
+	if err != nil {
+		return err
+	}
+	return write(toScan(h, distances, timestamp))
+}
+
+func runStream(h *lidar.HokuyoLidar, args []string) error {
+	fs := flag.NewFlagSet("stream", flag.ExitOnError)
+	sf := newScanFlags(fs)
+	interval := fs.Int("interval", 0, "number of scans skipped between transmitted scans")
+	count := fs.Int("n", 0, "number of scans to stream, 0 streams until interrupted")
+	fs.Parse(args)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	write, err := newScanWriter(*sf.format, out)
+	if err != nil {
+		return err
+	}
+
+	// the sensor counts at most 99 scans, longer streams are stopped by QT
+	numberOfScans := *count
+	if numberOfScans > 99 {
+		numberOfScans = 0
+	}
+	if err := h.MDMSCmd(!*sf.two, *sf.start, *sf.end, *sf.cluster, *interval, numberOfScans, ""); err != nil {
+		return err
+	}
+	defer h.QMCommand("")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	for i := 0; *count == 0 || i < *count; i++ {
+		select {
+		case <-interrupt:
+			return nil
+		default:
+		}
+		distances, timestamp, err := h.GetDistance()
+		if err != nil {
+			return err
+		}
+		if err := write(toScan(h, distances, timestamp)); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLaser(h *lidar.HokuyoLidar, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Expected on or off")
+	}
+	switch args[0] {
+	case "on":
+		return h.BMCommand("")
+	case "off":
+		return h.QMCommand("")
+	}
+	return fmt.Errorf("Expected on or off, got %q", args[0])
+}
+
+func runReset(h *lidar.HokuyoLidar, args []string) error {
+	return h.RSCommand("")
+}
+
+func runSpeed(h *lidar.HokuyoLidar, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Expected a speed ratio from 00 to 10")
+	}
+	ratio, err := strconv.Atoi(args[0])
+	if err != nil || ratio < 0 || ratio > 10 {
+		return fmt.Errorf("Invalid speed ratio %q", args[0])
+	}
+	return h.CRCommand(fmt.Sprintf("%02d", ratio))
+}
+
+func runBaud(h *lidar.HokuyoLidar, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Expected a bit rate")
+	}
+	rate, err := strconv.Atoi(args[0])
+	if err != nil || rate <= 0 || rate > 999999 {
+		return fmt.Errorf("Invalid bit rate %q", args[0])
+	}
+	return h.SSCommand(fmt.Sprintf("%06d", rate), "")
+}
+
+func runSensitivity(h *lidar.HokuyoLidar, args []string) error {
+	if len(args) != 1 || (args[0] != "high" && args[0] != "normal") {
+		return errors.New("Expected high or normal")
+	}
+	return h.HSCommand(args[0] == "high", "")
+}
+
+func runTimeSync(h *lidar.HokuyoLidar, args []string) error {
+	fs := flag.NewFlagSet("time-sync", flag.ExitOnError)
+	samples := fs.Int("samples", 10, "number of time requests")
+	fs.Parse(args)
+
+	if _, err := h.TMCommand('0', ""); err != nil {
+		return fmt.Errorf("Failed to enter time adjust mode: %v", err)
+	}
+	defer h.TMCommand('2', "")
+
+	// the sample with the shortest round trip bounds the offset best
+	var bestOffset, bestRoundTrip time.Duration
+	for i := 0; i < *samples; i++ {
+		sent := time.Now()
+		sensor, err := h.TMCommand('1', "")
+		if err != nil {
+			return err
+		}
+		received := time.Now()
+		roundTrip := received.Sub(sent)
+		mid := sent.Add(roundTrip / 2)
+		// host time at which the sensor clock read zero
+		offset := time.Duration(mid.UnixNano()) - time.Duration(sensor)*time.Millisecond
+		if i == 0 || roundTrip < bestRoundTrip {
+			bestOffset, bestRoundTrip = offset, roundTrip
+		}
+	}
+	fmt.Printf("sensor clock zero: %v\nround trip: %v\n", time.Unix(0, int64(bestOffset)).Format(time.RFC3339Nano), bestRoundTrip)
+	return nil
+}
+
+func runServe(h *lidar.HokuyoLidar, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.Parse(args)
+
+	metrics := lidar.NewDriverMetrics()
+	h.SetMetrics(metrics)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/", server.New(h).Handler())
+
+	fmt.Fprintf(os.Stderr, "serving on %v\n", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
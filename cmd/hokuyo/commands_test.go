@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+)
+
+// sensor replays the responses of a sensor and records the commands.
+type sensor struct {
+	commands bytes.Buffer
+	replies  *strings.Reader
+}
+
+func (s *sensor) Read(b []byte) (int, error)  { return s.replies.Read(b) }
+func (s *sensor) Write(b []byte) (int, error) { return s.commands.Write(b) }
+func (s *sensor) Close() error                { return nil }
+
+func TestScanCommand(t *testing.T) {
+	// BM, a GD scan of steps 384 to 386 with distances 1000, 1001 and
+	// 4095 at time 100, and QT
+	replies := "BM\n00P\n\n" +
+		"GD0384038601\n00P\n" + "001TU\n" + "0?X0?Y0ooM\n" + "\n" +
+		"QT\n00P\n\n"
+	s := &sensor{replies: strings.NewReader(replies)}
+	h := lidar.NewHokuyoLidar("", 0)
+	if err := h.ConnectPort(s); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := scan(h, []string{"-start", "384", "-end", "386", "-format", "csv"}, &out); err != nil {
+		t.Fatalf("Expected a scan, got %v\n", err)
+	}
+	if out.String() != "100,1000,1001,4095\n" {
+		t.Fatalf("Expected the scan as CSV, got %q\n", out.String())
+	}
+	if c := s.commands.String(); c != "BM\nGD0384038601\nQT\n" {
+		t.Fatalf("Expected BM, GD and QT, got %q\n", c)
+	}
+	if s.replies.Len() != 0 {
+		t.Fatalf("Expected every response to be read, %d bytes left\n", s.replies.Len())
+	}
+}
+
+func TestSpeedCommand(t *testing.T) {
+	s := &sensor{replies: strings.NewReader("CR05\n00P\n\n")}
+	h := lidar.NewHokuyoLidar("", 0)
+	if err := h.ConnectPort(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runSpeed(h, []string{"5"}); err != nil {
+		t.Fatalf("Expected the speed to change, got %v\n", err)
+	}
+	if c := s.commands.String(); c != "CR05\n" {
+		t.Fatalf("Expected CR05, got %q\n", c)
+	}
+	if s.replies.Len() != 0 {
+		t.Fatalf("Expected every response to be read, %d bytes left\n", s.replies.Len())
+	}
+	if err := h.CRCommand("5"); err == nil {
+		t.Fatal("Expected a one character speed ratio to be rejected")
+	}
+}
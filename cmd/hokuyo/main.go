@@ -0,0 +1,96 @@
+// Command hokuyo talks to a Hokuyo URG lidar over SCIP 2.0.
+//
+// Usage:
+//
+//	hokuyo [-port /dev/ttyACM0] [-baud 115200] [-scip1] <command> [arguments]
+//
+// The commands are:
+//
+//	info                  print version (VV), specification (PP) and state (II)
+//	scan                  take a single scan (GD/GS)
+//	stream                stream scans (MD/MS) as JSON lines or CSV
+//	view                  show the live scan in the terminal
+//	laser on|off          switch the laser on (BM) or off (QT)
+//	reset                 reset the sensor settings (RS)
+//	speed <ratio>         set the motor speed ratio 00-10 (CR)
+//	baud <rate>           change the RS232 bit rate (SS)
+//	sensitivity high|normal  switch the sensitivity mode (HS)
+//	time-sync             estimate the offset of the sensor clock (TM)
+//	serve                 serve the sensor over HTTP and WebSocket, with metrics on /metrics
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(h *lidar.HokuyoLidar, args []string) error
+}
+
+var commands = []command{
+	{"info", "", runInfo},
+	{"scan", "[-start 44] [-end 725] [-cluster 1] [-two] [-format json|csv]", runScan},
+	{"stream", "[-start 44] [-end 725] [-cluster 1] [-interval 0] [-n 0] [-two] [-format json|csv]", runStream},
+	{"view", "[-start 44] [-end 725] [-cluster 1] [-two] [-ascii]", runView},
+	{"laser", "on|off", runLaser},
+	{"reset", "", runReset},
+	{"speed", "<00-10>", runSpeed},
+	{"baud", "<019200|038400|057600|115200|250000|500000|750000>", runBaud},
+	{"sensitivity", "high|normal", runSensitivity},
+	{"time-sync", "[-samples 10]", runTimeSync},
+	{"serve", "[-addr :8080]", runServe},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: hokuyo [flags] <command> [arguments]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	port := flag.String("port", "/dev/ttyACM0", "serial port of the sensor")
+	baud := flag.Int("baud", 115200, "bit rate of the serial port")
+	scip1 := flag.Bool("scip1", false, "switch a sensor running SCIP 1.1 to SCIP 2.0")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "hokuyo: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	h := lidar.NewHokuyoLidar(*port, *baud)
+	if err := h.Connect(*scip1); err != nil {
+		fmt.Fprintf(os.Stderr, "hokuyo: failed to connect to %v: %v\n", *port, err)
+		os.Exit(1)
+	}
+	err := cmd.run(h, flag.Args()[1:])
+	if derr := h.Disconnect(); err == nil && derr != nil {
+		err = derr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hokuyo %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/go-gl/mathgl/mgl64"
+	"golang.org/x/term"
+)
+
+// frame is a scan prepared for display.
+type frame struct {
+	timestamp  int
+	distances  []int
+	points     []mgl64.Vec2
+	startAngle float64
+	step       float64
+}
+
+// viewer holds the display state of the live view.
+type viewer struct {
+	rangeMM  float64 // distance from the center to the top edge
+	ascii    bool
+	rings    bool
+	paused   bool
+	cursor   int // selected step, -1 for none
+	live     *frame
+	frozen   *frame // overlay kept for comparison
+	scans    int
+	lastErr  error
+	quitting bool
+}
+
+func newViewer(ascii bool) *viewer {
+	return &viewer{rangeMM: float64(lidar.DMAX), ascii: ascii, rings: true, cursor: -1}
+}
+
+const viewHelp = "q quit  +/- zoom  space pause  f freeze  left/right step  r rings  b braille/ascii"
+
+// update takes a new scan unless the view is paused.
+func (v *viewer) update(f *frame) {
+	v.scans++
+	if !v.paused {
+		v.live = f
+	}
+}
+
+// key handles a key press. Arrow keys arrive as escape sequences and are
+// mapped to h and l before.
+func (v *viewer) key(k byte) {
+	switch k {
+	case 'q', 3: // ctrl-c in raw mode
+		v.quitting = true
+	case '+', '=':
+		v.rangeMM = math.Max(250, v.rangeMM/1.5)
+	case '-', '_':
+		v.rangeMM = math.Min(float64(lidar.DMAX)*2, v.rangeMM*1.5)
+	case ' ', 'p':
+		v.paused = !v.paused
+	case 'f':
+		if v.frozen != nil {
+			v.frozen = nil
+		} else {
+			v.frozen = v.live
+		}
+	case 'r':
+		v.rings = !v.rings
+	case 'b':
+		v.ascii = !v.ascii
+	case 'h', 'l':
+		if v.live == nil || len(v.live.distances) == 0 {
+			return
+		}
+		n := len(v.live.distances)
+		if v.cursor < 0 {
+			v.cursor = n / 2
+		} else if k == 'h' {
+			v.cursor = (v.cursor + 1) % n
+		} else {
+			v.cursor = (v.cursor - 1 + n) % n
+		}
+	case 'c':
+		v.cursor = -1
+	}
+}
+
+// render draws the view into a screen of cols by rows characters. The
+// front of the sensor points up, the last two rows hold the status.
+func (v *viewer) render(cols, rows int) string {
+	plotRows := rows - 2
+	if plotRows < 1 || cols < 1 {
+		return ""
+	}
+	c := newCanvas(cols, plotRows, v.ascii)
+	cx, cy := c.width()/2, c.height()/2
+	// terminal cells are about twice as high as wide, braille dots square
+	aspect := 1.0
+	if v.ascii {
+		aspect = 0.5
+	}
+	scale := float64(c.height()/2) / v.rangeMM // dots per millimeter
+	toDot := func(p mgl64.Vec2) (int, int) {
+		// x forward is up, y left is left
+		return cx - int(math.Round(p[1]*scale/aspect)), cy - int(math.Round(p[0]*scale))
+	}
+
+	if v.rings {
+		for r := 1000.0; r <= v.rangeMM*2; r += 1000 {
+			for a := 0.0; a < 2*math.Pi; a += 1.0 / (r * scale) {
+				x, y := toDot(mgl64.Vec2{r * math.Cos(a), r * math.Sin(a)})
+				c.set(x, y, '.')
+			}
+		}
+	}
+	if v.frozen != nil {
+		for _, p := range v.frozen.points {
+			if p[0] != 0 || p[1] != 0 {
+				x, y := toDot(p)
+				c.set(x, y, overlayMark)
+			}
+		}
+	}
+	if v.live != nil {
+		if v.cursor >= 0 && v.cursor < len(v.live.distances) {
+			theta := v.live.startAngle + float64(v.cursor)*v.live.step
+			x, y := toDot(mgl64.Vec2{v.rangeMM * 2 * math.Cos(theta), v.rangeMM * 2 * math.Sin(theta)})
+			c.line(cx, cy, x, y, '.')
+		}
+		for _, p := range v.live.points {
+			if p[0] != 0 || p[1] != 0 {
+				x, y := toDot(p)
+				c.set(x, y, '*')
+			}
+		}
+	}
+	c.set(cx, cy, '@')
+
+	if v.rings {
+		for r := 1000.0; r <= v.rangeMM; r += 1000 {
+			x, y := toDot(mgl64.Vec2{r, 0})
+			if !v.ascii {
+				x, y = x/2, y/4
+			}
+			c.label(x+1, y, fmt.Sprintf("%.0fm", r/1000))
+		}
+	}
+
+	status := fmt.Sprintf("range %.1fm  scans %d", v.rangeMM/1000, v.scans)
+	if v.live != nil {
+		status += fmt.Sprintf("  t=%dms", v.live.timestamp)
+	}
+	if v.paused {
+		status += "  PAUSED"
+	}
+	if v.frozen != nil && v.ascii {
+		status += "  FROZEN(o)"
+	} else if v.frozen != nil {
+		status += "  FROZEN(dim)"
+	}
+	if v.live != nil && v.cursor >= 0 && v.cursor < len(v.live.distances) {
+		theta := v.live.startAngle + float64(v.cursor)*v.live.step
+		status += fmt.Sprintf("  step %d  %.1fdeg  %dmm", v.cursor, theta*180/math.Pi, v.live.distances[v.cursor])
+	}
+	if v.lastErr != nil {
+		status += "  error: " + v.lastErr.Error()
+	}
+	return c.String() + "\r\n" + fit(status, cols) + "\r\n" + fit(viewHelp, cols)
+}
+
+func fit(s string, cols int) string {
+	if len(s) > cols {
+		return s[:cols]
+	}
+	return s + strings.Repeat(" ", cols-len(s))
+}
+
+// readKeys forwards key presses, translating the arrow key escape
+// sequences to h and l.
+func readKeys(keys chan<- byte) {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			close(keys)
+			return
+		}
+		if b == 0x1b {
+			if next, _ := r.ReadByte(); next == '[' {
+				switch arrow, _ := r.ReadByte(); arrow {
+				case 'D':
+					b = 'h'
+				case 'C':
+					b = 'l'
+				case 'A':
+					b = '+'
+				case 'B':
+					b = '-'
+				}
+			}
+		}
+		keys <- b
+	}
+}
+
+// The backoff after a failed scan doubles up to maxBackoff.
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// readFrames reads scans until done is closed. It backs off after an error
+// and stops once the sensor can't stream anymore.
+func readFrames(h *lidar.HokuyoLidar, frames chan<- *frame, errs chan<- error, done <-chan struct{}) {
+	backoff := minBackoff
+	for {
+		distances, timestamp, err := h.GetDistance()
+		if err != nil {
+			select {
+			case errs <- err:
+			case <-done:
+				return
+			}
+			if errors.Is(err, lidar.ErrInvalidState) || errors.Is(err, lidar.ErrNotConnected) {
+				return
+			}
+			select {
+			case <-time.After(backoff):
+			case <-done:
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		f := &frame{
+			timestamp:  timestamp,
+			distances:  distances,
+			points:     h.DataToCartesian(distances),
+			startAngle: h.StartAngle(),
+			step:       h.AngularStep(),
+		}
+		select {
+		case frames <- f:
+		case <-done:
+			return
+		}
+	}
+}
+
+func runView(h *lidar.HokuyoLidar, args []string) error {
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	sf := newScanFlags(fs)
+	ascii := fs.Bool("ascii", false, "draw with ASCII characters instead of braille")
+	fs.Parse(args)
+
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return fmt.Errorf("view needs an interactive terminal")
+	}
+	if err := h.MDMSCmd(!*sf.two, *sf.start, *sf.end, *sf.cluster, 0, 0, ""); err != nil {
+		return err
+	}
+	defer h.QMCommand("")
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, state)
+	fmt.Print("\x1b[?25l\x1b[2J") // hide the cursor and clear
+	defer fmt.Print("\x1b[?25h\x1b[2J\x1b[H")
+
+	frames := make(chan *frame)
+	errs := make(chan error)
+	done := make(chan struct{})
+	defer close(done) // before QT, which waits for the pending read
+	go readFrames(h, frames, errs, done)
+	keys := make(chan byte)
+	go readKeys(keys)
+
+	v := newViewer(*ascii)
+	for !v.quitting {
+		select {
+		case f := <-frames:
+			v.update(f)
+			v.lastErr = nil
+		case err := <-errs:
+			v.lastErr = err
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			v.key(k)
+		}
+		cols, rows, err := term.GetSize(out)
+		if err != nil {
+			cols, rows = 80, 24
+		}
+		fmt.Print("\x1b[H" + v.render(cols, rows))
+	}
+	return nil
+}
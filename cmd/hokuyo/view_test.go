@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestCanvasBraille(t *testing.T) {
+	c := newCanvas(2, 1, false)
+	c.set(0, 0, '*')
+	c.set(3, 3, '*')
+	if s := c.String(); s != "⠁⢀" {
+		t.Fatalf("Expected two braille dots, got %q\n", s)
+	}
+}
+
+func TestCanvasOverlay(t *testing.T) {
+	c := newCanvas(3, 1, false)
+	c.set(0, 0, overlayMark)
+	c.set(1, 0, '*')
+	c.set(2, 0, overlayMark)
+	for x := 4; x < 6; x++ {
+		for y := 0; y < 4; y++ {
+			c.set(x, y, '*')
+		}
+	}
+	// the overlay is dimmed and hidden behind the live dots
+	if s := c.String(); s != "⠈\x1b[2m⠁\x1b[22m⣿" {
+		t.Fatalf("Expected a live dot, a dimmed overlay dot and a full cell, got %q\n", s)
+	}
+	c.label(2, 0, "x")
+	if s := c.String(); s != "⠈\x1b[2m⠁\x1b[22mx" {
+		t.Fatalf("Expected the label to replace the dots, got %q\n", s)
+	}
+}
+
+func TestViewerRender(t *testing.T) {
+	v := newViewer(true)
+	v.rings = false
+	v.update(&frame{distances: []int{1000}, points: []mgl64.Vec2{{1000, 0}}, step: 0.01})
+	v.key('l')
+	screen := v.render(80, 22)
+	lines := strings.Split(screen, "\r\n")
+	if len(lines) != 22 {
+		t.Fatalf("Expected 22 lines, got %v\n", len(lines))
+	}
+	// the point straight ahead is drawn above the sensor in the center column
+	if lines[10][40] != '@' || !strings.Contains(strings.Join(lines[:10], ""), "*") {
+		t.Fatalf("Expected the sensor and a point ahead of it, got\n%v\n", screen)
+	}
+	if !strings.Contains(lines[20], "step 0") || !strings.Contains(lines[20], "1000mm") {
+		t.Fatalf("Expected a readout of the selected step, got %q\n", lines[20])
+	}
+}
+
+func TestReadFramesStops(t *testing.T) {
+	frames := make(chan *frame)
+	errs := make(chan error)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readFrames(lidar.NewHokuyoLidar("", 0), frames, errs, done)
+		close(stopped)
+	}()
+	<-errs
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the reader to stop when the lidar is disconnected")
+	}
+
+	// without a stream every read fails, the reader retries until done
+	h := lidar.NewHokuyoLidar("", 0)
+	h.ConnectPort(&sensor{replies: strings.NewReader("")})
+	stopped = make(chan struct{})
+	go func() {
+		readFrames(h, frames, errs, done)
+		close(stopped)
+	}()
+	<-errs
+	<-errs
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the reader to stop when the view quits")
+	}
+}
@@ -0,0 +1,72 @@
+// Command legeval evaluates the leg detector on labelled recordings.
+//
+// Usage:
+//
+//	legeval [-train train.jsonl] [-quantile 0.05] [-match 150] recording.jsonl...
+//
+// The recordings are JSON lines as written by "hokuyo stream", with the leg
+// positions of every scan added as labels. With -train the thresholds are
+// trained on a labelled recording first, otherwise the default thresholds
+// are evaluated.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/legs"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "legeval: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("legeval", flag.ExitOnError)
+	train := fs.String("train", "", "labelled recording to train the thresholds on")
+	quantile := fs.Float64("quantile", 0.05, "quantile of the leg features at which the bounds are placed, 0-0.5")
+	match := fs.Float64("match", 150, "largest distance in millimeters between a detection and its label")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("Expected labelled recordings to evaluate")
+	}
+
+	cfg := legs.DefaultConfig()
+	if *train != "" {
+		f, err := os.Open(*train)
+		if err != nil {
+			return err
+		}
+		samples, err := legs.Samples(f, cfg.Segment, *match)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to read %v: %v", *train, err)
+		}
+		if cfg.Thresholds, err = legs.Train(samples, *quantile); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "thresholds: %+v\n", cfg.Thresholds)
+	}
+
+	d := legs.NewDetector(cfg)
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		e, err := legs.Evaluate(f, d, *match)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to read %v: %v", name, err)
+		}
+		fmt.Fprintf(w, "%s: %d scans, %d true positives, %d false positives, %d false negatives, precision %.3f, recall %.3f\n",
+			name, e.Scans, e.TruePositives, e.FalsePositives, e.FalseNegatives, e.Precision(), e.Recall())
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestRun(t *testing.T) {
+	// a wall at x = 3000 and a label where no leg is
+	s := recording.Scan{StartAngle: -0.5, Step: 2 * math.Pi / 1024, Labels: []mgl64.Vec2{{1500, 0}}}
+	for i := 0; i < 160; i++ {
+		s.Distances = append(s.Distances, int(3000/math.Cos(s.Angle(i))))
+	}
+	name := filepath.Join(t.TempDir(), "walls.jsonl")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := recording.NewWriter(f)
+	w.Write(s)
+	w.Write(s)
+	f.Close()
+
+	var out bytes.Buffer
+	if err := run([]string{"-train", name, name}, &out); err != nil {
+		t.Fatalf("Expected an evaluation, got %v\n", err)
+	}
+	if !strings.Contains(out.String(), "thresholds:") ||
+		!strings.Contains(out.String(), "2 scans, 0 true positives, 0 false positives, 2 false negatives") {
+		t.Fatalf("Unexpected output %q\n", out.String())
+	}
+	if err := run([]string{"-quantile", "0.7", "-train", name, name}, &out); err == nil {
+		t.Fatal("Expected an invalid quantile to be rejected")
+	}
+}
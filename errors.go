@@ -0,0 +1,91 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors of the driver. Use errors.Is to test for them, the
+// returned errors usually wrap them with more context.
+var (
+	ErrNotConnected      = errors.New("Lidar is not connected")
+	ErrTimeout           = errors.New("Timed out waiting for the sensor")
+	ErrChecksum          = errors.New("Checksum mismatch")
+	ErrAlreadyInMode     = errors.New("Sensor is already in the requested mode")
+	ErrIncompatibleModel = errors.New("Not compatible with the sensor model")
+	ErrStreaming         = errors.New("Sensor is streaming, stop the stream with QMCommand first")
+)
+
+// StatusError is a status code reported by the sensor in response to a
+// command. Codes meaning that the sensor already is in the requested mode
+// or that the command is not supported by the model match ErrAlreadyInMode
+// and ErrIncompatibleModel with errors.Is.
+type StatusError struct {
+	Command string // e.g. "MD", "SS"
+	Code    string // two digit status code
+	Meaning string
+
+	sentinel error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: status %v: %v", e.Command, e.Code, e.Meaning)
+}
+
+// Unwrap returns the sentinel error matching the status, if any.
+func (e *StatusError) Unwrap() error {
+	return e.sentinel
+}
+
+type status struct {
+	meaning  string
+	sentinel error
+}
+
+// commandStatus holds the status codes of the commands whose codes differ
+// from healthStatus.
+var commandStatus = map[string]map[string]status{
+	"BM": {
+		"01": {"Unable to control due to laser malfunction", nil},
+		"02": {"Laser is already on", ErrAlreadyInMode},
+	},
+	"SS": {
+		"01": {"Bit rate has non-numeric value", nil},
+		"02": {"Invalid bit rate", nil},
+		"03": {"Sensor is already running at defined bit rate", ErrAlreadyInMode},
+		"04": {"Not compatible with the sensor model", ErrIncompatibleModel},
+	},
+	"HS": {
+		"01": {"Parameter error", nil},
+		"02": {"Already running in set mode", ErrAlreadyInMode},
+		"03": {"Incompatible with current sensor model", ErrIncompatibleModel},
+	},
+	"CR": {
+		"01": {"Invalid speed ratio", nil},
+		"02": {"Speed ratio out of range", nil},
+		"03": {"Motor is already running at defined speed", ErrAlreadyInMode},
+		"04": {"Incompatible with current sensor model", ErrIncompatibleModel},
+	},
+	"TM": {
+		"01": {"Invalid control code", nil},
+		"02": {"Adjust mode on when already on", ErrAlreadyInMode},
+		"03": {"Adjust mode off when already off", ErrAlreadyInMode},
+		"04": {"Adjust mode off when time requested", nil},
+	},
+}
+
+// statusError returns nil for the success codes of SCIP 2.0 and a
+// *StatusError for any other code.
+func statusError(command, code string) error {
+	if code == "00" || code == "99" {
+		return nil
+	}
+	if codes, ok := commandStatus[command]; ok {
+		if s, ok := codes[code]; ok {
+			return &StatusError{command, code, s.meaning, s.sentinel}
+		}
+	} else if meaning, ok := healthStatus[code]; ok {
+		return &StatusError{command, code, meaning, nil}
+	}
+	return &StatusError{command, code, "Unknown status", nil}
+}
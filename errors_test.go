@@ -0,0 +1,67 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	if err := statusError("MD", "99"); err != nil {
+		t.Fatalf("Expected no error for status 99, got %v\n", err)
+	}
+
+	err := fmt.Errorf("Failed to change the bit rate: %w", statusError("SS", "03"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Expected a StatusError in %v\n", err)
+	}
+	if statusErr.Command != "SS" || statusErr.Code != "03" {
+		t.Fatalf("Unexpected status error %+v\n", statusErr)
+	}
+	if !errors.Is(err, ErrAlreadyInMode) || errors.Is(err, ErrIncompatibleModel) {
+		t.Fatalf("Expected %v to match only ErrAlreadyInMode\n", err)
+	}
+
+	if err := statusError("CR", "04"); !errors.Is(err, ErrIncompatibleModel) {
+		t.Fatalf("Expected %v to match ErrIncompatibleModel\n", err)
+	}
+	if err := statusError("BM", "02"); !errors.Is(err, ErrAlreadyInMode) {
+		t.Fatalf("Expected %v to match ErrAlreadyInMode\n", err)
+	}
+	err = statusError("GD", "04")
+	if !errors.As(err, &statusErr) || statusErr.Meaning != healthStatus["04"] {
+		t.Fatalf("Expected the meaning of status 04, got %v\n", err)
+	}
+	err = statusError("GD", "42")
+	if !errors.As(err, &statusErr) || statusErr.Meaning != "Unknown status" {
+		t.Fatalf("Expected an unknown status, got %v\n", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	if err := h.BMCommand(""); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected, got %v\n", err)
+	}
+	if err := h.Disconnect(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected, got %v\n", err)
+	}
+	if err := h.verify([]byte("00"), 'Q'); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("Expected ErrChecksum, got %v\n", err)
+	}
+	if err := h.verify([]byte("00"), 'P'); err != nil {
+		t.Fatalf("Expected a valid checksum, got %v\n", err)
+	}
+}
+
+func TestLaserAlreadyOn(t *testing.T) {
+	h, _ := connectedLidar(append([]byte("BM\n"), append(withSum([]byte("02")), lf)...))
+	h.state = StateIdle
+	if err := h.BMCommand(""); !errors.Is(err, ErrAlreadyInMode) {
+		t.Fatalf("Expected ErrAlreadyInMode, got %v\n", err)
+	}
+	if h.State() != StateLaserOn {
+		t.Fatalf("Expected %v, got %v\n", StateLaserOn, h.State())
+	}
+}
@@ -0,0 +1,70 @@
+package features
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Corner is the intersection of two adjacent line segments.
+type Corner struct {
+	Point mgl64.Vec2 // intersection of the two lines
+	Angle float64    // angle between the two segments in radians
+	Lines [2]int     // indices of the two lines in the input slice
+}
+
+// CornerConfig holds the parameters of the corner detector.
+type CornerConfig struct {
+	// MaxGap is the largest distance in millimeters between the end of one
+	// segment and the start of the next for them to be considered adjacent.
+	MaxGap float64
+	// MinAngle is the smallest angle in radians between two segments that
+	// forms a corner.
+	MinAngle float64
+}
+
+// DefaultCornerConfig returns parameters suited for the URG-04LX.
+func DefaultCornerConfig() CornerConfig {
+	return CornerConfig{
+		MaxGap:   150.0,
+		MinAngle: 30.0 * math.Pi / 180.0,
+	}
+}
+
+// Corners finds the intersections of adjacent segments. The lines are
+// expected in scan order, as returned by SplitAndMerge or RANSAC.
+func Corners(lines []Line, cfg CornerConfig) []Corner {
+	corners := []Corner{}
+	for i := 0; i+1 < len(lines); i++ {
+		a, b := lines[i], lines[i+1]
+		if a.End.Sub(b.Start).Len() > cfg.MaxGap {
+			continue
+		}
+		angle := math.Acos(clamp(a.Direction().Dot(b.Direction()), -1.0, 1.0))
+		if angle < cfg.MinAngle || angle > math.Pi-cfg.MinAngle {
+			continue
+		}
+		p, ok := Intersect(a, b)
+		if !ok {
+			continue
+		}
+		corners = append(corners, Corner{Point: p, Angle: angle, Lines: [2]int{i, i + 1}})
+	}
+	return corners
+}
+
+// Intersect returns the intersection of the infinite lines through a and b.
+// ok is false if the lines are parallel.
+func Intersect(a, b Line) (p mgl64.Vec2, ok bool) {
+	det := math.Sin(b.Alpha - a.Alpha)
+	if math.Abs(det) < 1e-9 {
+		return mgl64.Vec2{}, false
+	}
+	x := (a.Rho*math.Sin(b.Alpha) - b.Rho*math.Sin(a.Alpha)) / det
+	y := (b.Rho*math.Cos(a.Alpha) - a.Rho*math.Cos(b.Alpha)) / det
+	return mgl64.Vec2{x, y}, true
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
@@ -0,0 +1,58 @@
+package features
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// corridorCorner returns points along the wall x = 1000 followed by points
+// along the wall y = 1000, meeting in a corner at (1000, 1000).
+func corridorCorner() []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for y := 0.0; y < 1000; y += 20 {
+		points = append(points, mgl64.Vec2{1000, y})
+	}
+	for x := 1000.0; x >= 0; x -= 20 {
+		points = append(points, mgl64.Vec2{x, 1000})
+	}
+	return points
+}
+
+func TestFitLine(t *testing.T) {
+	points := []mgl64.Vec2{{1000, 0}, {1000, 100}, {1000, 200}, {1000, 300}}
+	l := FitLine(points, []int{0, 1, 2, 3})
+	if math.Abs(l.Rho-1000) > 1e-6 || math.Abs(l.Alpha) > 1e-6 {
+		t.Fatalf("Expected rho 1000 alpha 0, got %v %v\n", l.Rho, l.Alpha)
+	}
+	if l.Length() != 300 {
+		t.Fatalf("Expected length 300, got %v\n", l.Length())
+	}
+}
+
+func TestSplitAndMerge(t *testing.T) {
+	lines := SplitAndMerge(corridorCorner(), DefaultSplitMergeConfig())
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v\n", len(lines))
+	}
+	corners := Corners(lines, DefaultCornerConfig())
+	if len(corners) != 1 {
+		t.Fatalf("Expected 1 corner, got %v\n", len(corners))
+	}
+	if d := corners[0].Point.Sub(mgl64.Vec2{1000, 1000}).Len(); d > 1 {
+		t.Fatalf("Expected corner at (1000, 1000), got %v\n", corners[0].Point)
+	}
+}
+
+func TestRANSAC(t *testing.T) {
+	lines := RANSAC(corridorCorner(), DefaultRANSACConfig())
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v\n", len(lines))
+	}
+	for _, l := range lines {
+		if math.Abs(l.Rho-1000) > 1 {
+			t.Fatalf("Expected lines at distance 1000, got %v\n", l.Rho)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package features extracts geometric features such as line segments and
+// corners from cartesian scans.
+package features
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Line is a line segment fitted to a set of scan points. The infinite line
+// is stored in Hessian normal form: x*cos(Alpha) + y*sin(Alpha) = Rho.
+type Line struct {
+	Start      mgl64.Vec2 // projection of the first supporting point
+	End        mgl64.Vec2 // projection of the last supporting point
+	Rho        float64    // distance of the line to the origin
+	Alpha      float64    // angle of the line normal in radians
+	Covariance mgl64.Mat2 // covariance of (Rho, Alpha)
+	Indices    []int      // indices of the supporting points in the scan
+}
+
+// Normal returns the unit normal of the line.
+func (l Line) Normal() mgl64.Vec2 {
+	return mgl64.Vec2{math.Cos(l.Alpha), math.Sin(l.Alpha)}
+}
+
+// Direction returns the unit direction of the line from Start to End.
+func (l Line) Direction() mgl64.Vec2 {
+	d := l.End.Sub(l.Start)
+	if d.Len() == 0 {
+		return mgl64.Vec2{-math.Sin(l.Alpha), math.Cos(l.Alpha)}
+	}
+	return d.Normalize()
+}
+
+// Length returns the length of the segment.
+func (l Line) Length() float64 {
+	return l.End.Sub(l.Start).Len()
+}
+
+// Distance returns the perpendicular distance of p to the infinite line.
+func (l Line) Distance(p mgl64.Vec2) float64 {
+	return math.Abs(p.Dot(l.Normal()) - l.Rho)
+}
+
+// FitLine fits a line to the points at the given indices with total least
+// squares. At least two points are required.
+func FitLine(points []mgl64.Vec2, indices []int) Line {
+	n := float64(len(indices))
+	var c mgl64.Vec2
+	for _, i := range indices {
+		c = c.Add(points[i])
+	}
+	c = c.Mul(1.0 / n)
+
+	var sxx, syy, sxy float64
+	for _, i := range indices {
+		d := points[i].Sub(c)
+		sxx += d[0] * d[0]
+		syy += d[1] * d[1]
+		sxy += d[0] * d[1]
+	}
+	alpha := 0.5 * math.Atan2(-2.0*sxy, syy-sxx)
+	rho := c[0]*math.Cos(alpha) + c[1]*math.Sin(alpha)
+	if rho < 0 {
+		rho = -rho
+		alpha += math.Pi
+	}
+	alpha = normalizeAngle(alpha)
+
+	l := Line{Rho: rho, Alpha: alpha, Indices: append([]int{}, indices...)}
+	normal := l.Normal()
+	tangent := mgl64.Vec2{-normal[1], normal[0]}
+
+	var residual, spread float64
+	for _, i := range indices {
+		d := points[i].Dot(normal) - rho
+		residual += d * d
+		t := points[i].Sub(c).Dot(tangent)
+		spread += t * t
+	}
+	variance := 0.0
+	if n > 2 {
+		variance = residual / (n - 2)
+	}
+	varAlpha := math.Inf(1)
+	if spread > 0 {
+		varAlpha = variance / spread
+	}
+	lever := c.Dot(tangent)
+	l.Covariance = mgl64.Mat2{
+		variance/n + lever*lever*varAlpha, lever * varAlpha,
+		lever * varAlpha, varAlpha,
+	}
+
+	first := points[indices[0]]
+	last := points[indices[len(indices)-1]]
+	l.Start = first.Sub(normal.Mul(first.Dot(normal) - rho))
+	l.End = last.Sub(normal.Mul(last.Dot(normal) - rho))
+	return l
+}
+
+func normalizeAngle(a float64) float64 {
+	for a > math.Pi {
+		a -= 2.0 * math.Pi
+	}
+	for a <= -math.Pi {
+		a += 2.0 * math.Pi
+	}
+	return a
+}
+
+func isInvalid(p mgl64.Vec2) bool {
+	return p[0] == 0 && p[1] == 0
+}
@@ -0,0 +1,131 @@
+package features
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// RANSACConfig holds the parameters of the RANSAC line extractor.
+type RANSACConfig struct {
+	// Iterations is the number of hypotheses drawn per extracted line.
+	Iterations int
+	// InlierDistance is the largest distance in millimeters of an inlier
+	// to the hypothesis.
+	InlierDistance float64
+	// MaxGap is the largest distance in millimeters between two consecutive
+	// inliers of the same segment.
+	MaxGap float64
+	// MinPoints is the smallest number of inliers a line may have.
+	MinPoints int
+	// Seed makes the extraction repeatable.
+	Seed int64
+}
+
+// DefaultRANSACConfig returns parameters suited for the URG-04LX.
+func DefaultRANSACConfig() RANSACConfig {
+	return RANSACConfig{
+		Iterations:     200,
+		InlierDistance: 20.0,
+		MaxGap:         200.0,
+		MinPoints:      10,
+		Seed:           1,
+	}
+}
+
+// RANSAC extracts line segments from the points of a scan by repeatedly
+// fitting the line with the most inliers and removing its support. Zero
+// vectors are treated as steps without a valid return. Inliers of a line
+// are split into separate segments where consecutive inliers are further
+// apart than MaxGap.
+func RANSAC(points []mgl64.Vec2, cfg RANSACConfig) []Line {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	remaining := []int{}
+	for i, p := range points {
+		if !isInvalid(p) {
+			remaining = append(remaining, i)
+		}
+	}
+
+	lines := []Line{}
+	for len(remaining) >= cfg.MinPoints && len(remaining) >= 2 {
+		best := []int{}
+		for it := 0; it < cfg.Iterations; it++ {
+			a := remaining[rng.Intn(len(remaining))]
+			b := remaining[rng.Intn(len(remaining))]
+			if a == b {
+				continue
+			}
+			inliers := inliersOf(points, remaining, FitLine(points, []int{a, b}), cfg.InlierDistance)
+			if len(inliers) > len(best) {
+				best = inliers
+			}
+		}
+		if len(best) < cfg.MinPoints {
+			break
+		}
+		// refine the hypothesis on all of its inliers
+		best = inliersOf(points, remaining, FitLine(points, best), cfg.InlierDistance)
+		if len(best) < cfg.MinPoints {
+			break
+		}
+
+		found := false
+		for _, run := range splitOnGaps(points, best, cfg.MaxGap) {
+			if len(run) >= cfg.MinPoints {
+				lines = append(lines, FitLine(points, run))
+				remaining = without(remaining, run)
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Indices[0] < lines[j].Indices[0]
+	})
+	return lines
+}
+
+func inliersOf(points []mgl64.Vec2, candidates []int, l Line, maxDist float64) []int {
+	inliers := []int{}
+	for _, i := range candidates {
+		if l.Distance(points[i]) <= maxDist {
+			inliers = append(inliers, i)
+		}
+	}
+	return inliers
+}
+
+func splitOnGaps(points []mgl64.Vec2, indices []int, maxGap float64) [][]int {
+	runs := [][]int{}
+	current := []int{}
+	for _, i := range indices {
+		if len(current) > 0 && points[i].Sub(points[current[len(current)-1]]).Len() > maxGap {
+			runs = append(runs, current)
+			current = []int{}
+		}
+		current = append(current, i)
+	}
+	if len(current) > 0 {
+		runs = append(runs, current)
+	}
+	return runs
+}
+
+func without(indices, remove []int) []int {
+	drop := make(map[int]bool, len(remove))
+	for _, i := range remove {
+		drop[i] = true
+	}
+	kept := []int{}
+	for _, i := range indices {
+		if !drop[i] {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,132 @@
+package features
+
+import (
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// SplitMergeConfig holds the parameters of the split-and-merge extractor.
+type SplitMergeConfig struct {
+	// SplitDistance is the largest distance in millimeters a point may have
+	// from the chord of its segment before the segment is split.
+	SplitDistance float64
+	// MergeDistance is the largest residual in millimeters allowed when two
+	// neighbouring segments are merged into one.
+	MergeDistance float64
+	// MaxGap is the largest distance in millimeters between two consecutive
+	// points of the same segment.
+	MaxGap float64
+	// MinPoints is the smallest number of points a line may have.
+	MinPoints int
+}
+
+// DefaultSplitMergeConfig returns parameters suited for the URG-04LX.
+func DefaultSplitMergeConfig() SplitMergeConfig {
+	return SplitMergeConfig{
+		SplitDistance: 30.0,
+		MergeDistance: 30.0,
+		MaxGap:        200.0,
+		MinPoints:     5,
+	}
+}
+
+// SplitAndMerge extracts line segments from the points of a scan in scan
+// order. Zero vectors are treated as steps without a valid return.
+func SplitAndMerge(points []mgl64.Vec2, cfg SplitMergeConfig) []Line {
+	lines := []Line{}
+	for _, run := range contiguousRuns(points, cfg.MaxGap) {
+		segments := split(points, run, cfg)
+		lines = append(lines, merge(points, segments, cfg)...)
+	}
+	return lines
+}
+
+func contiguousRuns(points []mgl64.Vec2, maxGap float64) [][]int {
+	runs := [][]int{}
+	current := []int{}
+	for i, p := range points {
+		if isInvalid(p) {
+			if len(current) > 0 {
+				runs = append(runs, current)
+				current = []int{}
+			}
+			continue
+		}
+		if len(current) > 0 && p.Sub(points[current[len(current)-1]]).Len() > maxGap {
+			runs = append(runs, current)
+			current = []int{}
+		}
+		current = append(current, i)
+	}
+	if len(current) > 0 {
+		runs = append(runs, current)
+	}
+	return runs
+}
+
+func split(points []mgl64.Vec2, indices []int, cfg SplitMergeConfig) [][]int {
+	if len(indices) < cfg.MinPoints || len(indices) < 2 {
+		return nil
+	}
+	first := points[indices[0]]
+	last := points[indices[len(indices)-1]]
+	chord := last.Sub(first)
+	length := chord.Len()
+
+	worst, worstDist := 0, 0.0
+	for k := 1; k < len(indices)-1; k++ {
+		d := points[indices[k]].Sub(first)
+		var dist float64
+		if length == 0 {
+			dist = d.Len()
+		} else {
+			dist = abs(chord[0]*d[1]-chord[1]*d[0]) / length
+		}
+		if dist > worstDist {
+			worst, worstDist = k, dist
+		}
+	}
+	if worstDist <= cfg.SplitDistance {
+		return [][]int{indices}
+	}
+	left := split(points, indices[:worst+1], cfg)
+	right := split(points, indices[worst:], cfg)
+	return append(left, right...)
+}
+
+func merge(points []mgl64.Vec2, segments [][]int, cfg SplitMergeConfig) []Line {
+	lines := []Line{}
+	for i := 0; i < len(segments); i++ {
+		current := segments[i]
+		for i+1 < len(segments) {
+			next := segments[i+1]
+			joined := append(append([]int{}, current...), next[1:]...)
+			if current[len(current)-1] != next[0] {
+				joined = append(append([]int{}, current...), next...)
+			}
+			if maxResidual(points, FitLine(points, joined)) > cfg.MergeDistance {
+				break
+			}
+			current = joined
+			i++
+		}
+		lines = append(lines, FitLine(points, current))
+	}
+	return lines
+}
+
+func maxResidual(points []mgl64.Vec2, l Line) float64 {
+	worst := 0.0
+	for _, i := range l.Indices {
+		if d := l.Distance(points[i]); d > worst {
+			worst = d
+		}
+	}
+	return worst
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,34 @@
+package fusion
+
+import (
+	"time"
+)
+
+// timestampWrap is the period of the 24 bit millisecond sensor clock.
+const timestampWrap = 1 << 24
+
+// ClockAligner maps sensor timestamps onto the host clock. The offset is
+// the smallest difference between the reception time and the sensor time
+// seen so far, since transmission latency only ever adds to it.
+type ClockAligner struct {
+	offset  time.Duration
+	started bool
+	last    int
+	wraps   int
+}
+
+// Align returns the host time at which a scan with the given sensor
+// timestamp in milliseconds was taken, given the time it was received.
+func (c *ClockAligner) Align(timestamp int, received time.Time) time.Time {
+	if c.started && timestamp < c.last && c.last-timestamp > timestampWrap/2 {
+		c.wraps++
+	}
+	c.last = timestamp
+	sensor := time.Duration(timestamp+c.wraps*timestampWrap) * time.Millisecond
+	offset := time.Duration(received.UnixNano()) - sensor
+	if !c.started || offset < c.offset {
+		c.offset = offset
+	}
+	c.started = true
+	return time.Unix(0, int64(sensor+c.offset))
+}
@@ -0,0 +1,216 @@
+// Package fusion merges the scans of several lidars mounted on the same
+// robot into a single point cloud or virtual scan around a common origin.
+package fusion
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Source is a lidar delivering scans, such as a connected *HokuyoLidar
+// after MDMSCmd.
+type Source interface {
+	GetDistance() ([]int, int, error)
+	DataToCartesian(distances []int) []mgl64.Vec2
+}
+
+// Sensor is a lidar and its mounting pose in the common frame.
+type Sensor struct {
+	Name      string
+	Source    Source
+	Extrinsic geom.Pose
+}
+
+// Config holds the parameters of the merger.
+type Config struct {
+	// MaxSkew is the largest time difference between the scans of a merge.
+	MaxSkew time.Duration
+}
+
+// DefaultConfig allows half a URG-04LX scan period between sensors.
+func DefaultConfig() Config {
+	return Config{MaxSkew: 50 * time.Millisecond}
+}
+
+// Frame is a scan of one sensor moved into the common frame.
+type Frame struct {
+	Sensor    int          // index of the sensor
+	Timestamp int          // sensor timestamp in milliseconds
+	Time      time.Time    // host time at which the scan was taken
+	Points    []mgl64.Vec2 // valid points in the common frame
+}
+
+// Merged is a set of time aligned frames, one per sensor.
+type Merged struct {
+	Time   time.Time // mean time of the frames
+	Frames []Frame
+}
+
+// Points returns the points of all frames.
+func (m Merged) Points() []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for _, f := range m.Frames {
+		points = append(points, f.Points...)
+	}
+	return points
+}
+
+// LaserScan renders the merged points as a virtual scan around the common
+// origin with n steps. Each step keeps its closest point, steps without a
+// point are 0 like an error code of the sensor.
+func (m Merged) LaserScan(startAngle, step float64, n int) recording.Scan {
+	s := recording.Scan{
+		Timestamp:  int(m.Time.UnixNano() / int64(time.Millisecond)),
+		StartAngle: startAngle,
+		Step:       step,
+		Distances:  make([]int, n),
+	}
+	for _, p := range m.Points() {
+		theta := geom.NormalizeAngle(math.Atan2(p[1], p[0]) - startAngle)
+		if theta < 0 {
+			theta += 2 * math.Pi
+		}
+		i := int(math.Round(theta / step))
+		if i < 0 || i >= n {
+			continue
+		}
+		d := int(p.Len())
+		if s.Distances[i] == 0 || d < s.Distances[i] {
+			s.Distances[i] = d
+		}
+	}
+	return s
+}
+
+// Merger collects the scans of several sensors and emits them together
+// once every sensor delivered a scan within MaxSkew of the others.
+type Merger struct {
+	config  Config
+	sensors []Sensor
+	clocks  []ClockAligner
+	latest  []*Frame
+	mutex   sync.Mutex
+}
+
+// NewMerger creates a merger for the sensors.
+func NewMerger(sensors []Sensor, config Config) *Merger {
+	return &Merger{
+		config:  config,
+		sensors: sensors,
+		clocks:  make([]ClockAligner, len(sensors)),
+		latest:  make([]*Frame, len(sensors)),
+	}
+}
+
+// Add stores a scan of a sensor received at the given host time and returns
+// a merge if it completes one.
+func (m *Merger) Add(sensor int, distances []int, timestamp int, received time.Time) (Merged, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.sensors[sensor]
+	m.latest[sensor] = &Frame{
+		Sensor:    sensor,
+		Timestamp: timestamp,
+		Time:      m.clocks[sensor].Align(timestamp, received),
+		Points:    s.Extrinsic.TransformAll(s.Source.DataToCartesian(distances)),
+	}
+
+	var first, last time.Time
+	for i, f := range m.latest {
+		if f == nil {
+			return Merged{}, false
+		}
+		if i == 0 || f.Time.Before(first) {
+			first = f.Time
+		}
+		if i == 0 || f.Time.After(last) {
+			last = f.Time
+		}
+	}
+	if last.Sub(first) > m.config.MaxSkew {
+		return Merged{}, false
+	}
+
+	merged := Merged{Frames: make([]Frame, len(m.latest))}
+	var sum time.Duration
+	for i, f := range m.latest {
+		merged.Frames[i] = *f
+		sum += f.Time.Sub(first)
+		m.latest[i] = nil
+	}
+	merged.Time = first.Add(sum / time.Duration(len(m.latest)))
+	return merged, true
+}
+
+// The backoff of a sensor after a read error doubles up to maxBackoff.
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// Run reads every sensor in its own goroutine and delivers the merges
+// until the context is cancelled. Read errors are sent on the error
+// channel without stopping the other sensors. A sensor backs off after an
+// error and stops once it can't stream anymore, e.g. when it was
+// disconnected. Both channels are closed once all readers stopped.
+func (m *Merger) Run(ctx context.Context) (<-chan Merged, <-chan error) {
+	out := make(chan Merged)
+	errs := make(chan error, len(m.sensors))
+	if len(m.sensors) == 0 {
+		errs <- errors.New("No sensors to merge")
+		close(out)
+		close(errs)
+		return out, errs
+	}
+	var wg sync.WaitGroup
+	for i := range m.sensors {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			backoff := minBackoff
+			for ctx.Err() == nil {
+				distances, timestamp, err := m.sensors[i].Source.GetDistance()
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					if errors.Is(err, lidar.ErrInvalidState) || errors.Is(err, lidar.ErrNotConnected) {
+						return
+					}
+					select {
+					case <-time.After(backoff):
+					case <-ctx.Done():
+						return
+					}
+					if backoff *= 2; backoff > maxBackoff {
+						backoff = maxBackoff
+					}
+					continue
+				}
+				backoff = minBackoff
+				if merged, ok := m.Add(i, distances, timestamp, time.Now()); ok {
+					select {
+					case out <- merged:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+		close(errs)
+	}()
+	return out, errs
+}
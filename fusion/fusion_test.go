@@ -0,0 +1,98 @@
+package fusion
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// fakeSource maps distance i to a point straight ahead of the sensor.
+type fakeSource struct{}
+
+func (fakeSource) GetDistance() ([]int, int, error) { return nil, 0, nil }
+
+func (fakeSource) DataToCartesian(distances []int) []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for _, d := range distances {
+		points = append(points, mgl64.Vec2{float64(d), 0})
+	}
+	return points
+}
+
+func TestClockAligner(t *testing.T) {
+	var c ClockAligner
+	base := time.Unix(1000, 0)
+	c.Align(100, base.Add(5*time.Millisecond))
+	aligned := c.Align(200, base.Add(102*time.Millisecond))
+	if want := base.Add(102 * time.Millisecond); !aligned.Equal(want) {
+		t.Fatalf("Expected %v, got %v\n", want, aligned)
+	}
+	// across the 24 bit wrap
+	c.Align(timestampWrap-50, base.Add(time.Duration(timestampWrap-148)*time.Millisecond))
+	wrapped := c.Align(50, base.Add(time.Duration(timestampWrap-48)*time.Millisecond))
+	if want := base.Add(time.Duration(timestampWrap-48) * time.Millisecond); !wrapped.Equal(want) {
+		t.Fatalf("Expected %v after the wrap, got %v\n", want, wrapped)
+	}
+}
+
+func TestMerger(t *testing.T) {
+	sensors := []Sensor{
+		{Name: "front", Source: fakeSource{}, Extrinsic: geom.Pose{X: 200}},
+		{Name: "rear", Source: fakeSource{}, Extrinsic: geom.Pose{X: -200, Theta: math.Pi}},
+	}
+	m := NewMerger(sensors, DefaultConfig())
+	now := time.Unix(1000, 0)
+	if _, ok := m.Add(0, []int{1000}, 0, now); ok {
+		t.Fatalf("Expected no merge with a single sensor\n")
+	}
+	merged, ok := m.Add(1, []int{500}, 5000, now.Add(10*time.Millisecond))
+	if !ok {
+		t.Fatalf("Expected a merge once both sensors delivered\n")
+	}
+	points := merged.Points()
+	if len(points) != 2 || points[0].Sub(mgl64.Vec2{1200, 0}).Len() > 1e-9 || points[1].Sub(mgl64.Vec2{-700, 0}).Len() > 1e-9 {
+		t.Fatalf("Unexpected merged points %v\n", points)
+	}
+
+	scan := merged.LaserScan(-math.Pi, 2*math.Pi/360, 360)
+	if scan.Distances[180] != 1200 || scan.Distances[0] != 700 {
+		t.Fatalf("Unexpected virtual scan %v %v\n", scan.Distances[180], scan.Distances[0])
+	}
+}
+
+// failingSource fails every read with err.
+type failingSource struct {
+	fakeSource
+	err   error
+	reads int32
+}
+
+func (f *failingSource) GetDistance() ([]int, int, error) {
+	atomic.AddInt32(&f.reads, 1)
+	return nil, 0, f.err
+}
+
+func TestRunErrors(t *testing.T) {
+	flaky := &failingSource{err: lidar.ErrChecksum}
+	gone := &failingSource{err: lidar.ErrNotConnected}
+	m := NewMerger([]Sensor{{Name: "flaky", Source: flaky}, {Name: "gone", Source: gone}}, DefaultConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	out, errs := m.Run(ctx)
+	for range out {
+	}
+	for range errs {
+	}
+	if n := atomic.LoadInt32(&gone.reads); n != 1 {
+		t.Fatalf("Expected a disconnected sensor to stop after one read, got %d\n", n)
+	}
+	if n := atomic.LoadInt32(&flaky.reads); n < 2 || n > 10 {
+		t.Fatalf("Expected a failing sensor to back off, got %d reads in 100ms\n", n)
+	}
+}
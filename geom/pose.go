@@ -0,0 +1,93 @@
+// Package geom provides the planar rigid transforms shared by the scan
+// processing packages.
+package geom
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Pose is a rigid 2D transform. X and Y are in millimeters, Theta in radians.
+type Pose struct {
+	X     float64
+	Y     float64
+	Theta float64
+}
+
+// Transform applies the pose to a point.
+func (p Pose) Transform(v mgl64.Vec2) mgl64.Vec2 {
+	s, c := math.Sincos(p.Theta)
+	return mgl64.Vec2{c*v[0] - s*v[1] + p.X, s*v[0] + c*v[1] + p.Y}
+}
+
+// TransformAll applies the pose to every point. Zero vectors, which mark
+// steps without a valid return, are dropped.
+func (p Pose) TransformAll(points []mgl64.Vec2) []mgl64.Vec2 {
+	out := make([]mgl64.Vec2, 0, len(points))
+	for _, v := range points {
+		if v[0] == 0 && v[1] == 0 {
+			continue
+		}
+		out = append(out, p.Transform(v))
+	}
+	return out
+}
+
+// Compose returns the pose obtained by applying o in the frame of p.
+func (p Pose) Compose(o Pose) Pose {
+	t := p.Transform(mgl64.Vec2{o.X, o.Y})
+	return Pose{t[0], t[1], NormalizeAngle(p.Theta + o.Theta)}
+}
+
+// Inverse returns the pose that undoes p.
+func (p Pose) Inverse() Pose {
+	s, c := math.Sincos(p.Theta)
+	return Pose{-c*p.X - s*p.Y, s*p.X - c*p.Y, NormalizeAngle(-p.Theta)}
+}
+
+// Between returns the pose of o relative to p.
+func (p Pose) Between(o Pose) Pose {
+	return p.Inverse().Compose(o)
+}
+
+// Vec returns the translation of the pose.
+func (p Pose) Vec() mgl64.Vec2 {
+	return mgl64.Vec2{p.X, p.Y}
+}
+
+// Align returns the rigid transform that maps the points of src onto the
+// corresponding points of dst with the least squared error. Both slices
+// must have the same, non zero, length.
+func Align(src, dst []mgl64.Vec2) Pose {
+	var ms, md mgl64.Vec2
+	for i := range src {
+		ms = ms.Add(src[i])
+		md = md.Add(dst[i])
+	}
+	n := float64(len(src))
+	ms = ms.Mul(1.0 / n)
+	md = md.Mul(1.0 / n)
+
+	var sin, cos float64
+	for i := range src {
+		a := src[i].Sub(ms)
+		b := dst[i].Sub(md)
+		cos += a[0]*b[0] + a[1]*b[1]
+		sin += a[0]*b[1] - a[1]*b[0]
+	}
+	theta := math.Atan2(sin, cos)
+	rot := Pose{Theta: theta}.Transform(ms)
+	return Pose{X: md[0] - rot[0], Y: md[1] - rot[1], Theta: theta}
+}
+
+// NormalizeAngle wraps an angle into (-pi, pi].
+func NormalizeAngle(a float64) float64 {
+	a = math.Mod(a, 2.0*math.Pi)
+	if a > math.Pi {
+		a -= 2.0 * math.Pi
+	} else if a <= -math.Pi {
+		a += 2.0 * math.Pi
+	}
+	return a
+}
@@ -0,0 +1,28 @@
+package geom
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComposeInverse(t *testing.T) {
+	p := Pose{100, -50, 0.7}
+	q := p.Compose(p.Inverse())
+	if math.Abs(q.X) > 1e-9 || math.Abs(q.Y) > 1e-9 || math.Abs(q.Theta) > 1e-9 {
+		t.Fatalf("Expected identity, got %v\n", q)
+	}
+	o := Pose{20, 30, -0.2}
+	b := p.Between(p.Compose(o))
+	if math.Abs(b.X-o.X) > 1e-9 || math.Abs(b.Y-o.Y) > 1e-9 || math.Abs(b.Theta-o.Theta) > 1e-9 {
+		t.Fatalf("Expected %v, got %v\n", o, b)
+	}
+}
+
+func TestNormalizeAngle(t *testing.T) {
+	if a := NormalizeAngle(3 * math.Pi); math.Abs(a-math.Pi) > 1e-9 {
+		t.Fatalf("Expected pi, got %v\n", a)
+	}
+	if a := NormalizeAngle(-1.5 * math.Pi); math.Abs(a-0.5*math.Pi) > 1e-9 {
+		t.Fatalf("Expected pi/2, got %v\n", a)
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-gl/mathgl/mgl64"
 
@@ -30,7 +31,7 @@ const (
 	cTag          byte = 0x43
 	hTag          byte = 0x48
 	gTag          byte = 0x47
-	pTag          byte = 0x56
+	pTag          byte = 0x50
 	iTag          byte = 0x49
 	vTag          byte = 0x56
 	threeEncoding byte = 0x44
@@ -46,10 +47,6 @@ const (
 	SCAN int = 600
 )
 
-var angleRange = 360.0 / ARES * AMAX
-var angleMin = -angleRange / 2.0
-var angleMax = angleRange / 2.0
-
 var healthStatus = map[string]string{
 	"00": "Command received without any Error",
 	"01": "Starting Step has non-numeric value",
@@ -62,37 +59,58 @@ var healthStatus = map[string]string{
 	"98": "Resumption of process after confirming normal laser operation",
 }
 
-// HokuyoLidar represents the lidar structure
+// HokuyoLidar represents the lidar structure. It is safe for concurrent
+// use: commands are serialized, and every command is validated against
+// the current State. While an MD/MS stream is running only GetDistance,
+// GetDistanceAndIntensity, QMCommand and RSCommand reach the sensor.
+// VVCommand and PPCommand answer from the last response during a stream,
+// the other commands fail with ErrStreaming.
 type HokuyoLidar struct {
+	mutex sync.Mutex // serializes the commands
+
 	// lidar related data
-	serialPort  *serial.Port
-	portName    string
-	baudrate    int
-	MotorActive bool
-	options     *serial.Options
-	Connected   bool
-	Scanning    bool
+	serialPort io.ReadWriteCloser // a *serial.Port once connected
+	portName   string
+	baudrate   int
+	options    *serial.Options
+
+	state       State
+	streamFrom  State // state to return to after a finite stream
+	scanPending bool  // a GD/GS response waits to be read
+	callbacks   []StateChange
+	transitions []transition // not yet passed to the callbacks
 
 	// scan operation related data
-	startStep    int
-	endStep      int
-	clusterCount int
-	scanInterval int
-	encodingType byte
-	headSize     int
-	requestTag   byte
+	spec       Spec
+	scan       ScanConfig
+	headSize   int
+	requestTag byte
+	scansLeft  int // of an MD/MS request, 0 if unlimited
+
+	// static answers served during a stream
+	version       []string
+	specification []string
+
+	// intensity correction applied by GetDistanceAndIntensity
+	intensityModel *IntensityModel
+
+	metrics     Metrics
+	log         Logger
+	connections int
 }
 
 // NewHokuyoLidar creates an instance of the lidar struct.
 func NewHokuyoLidar(portName string, baudrate int) *HokuyoLidar {
-	return &HokuyoLidar{nil, portName, baudrate, false, nil, false, false,
-		0, 0, 0, 0, 0, 0, 0}
+	spec := DefaultSpec()
+	return &HokuyoLidar{portName: portName, baudrate: baudrate, spec: spec, scan: DefaultScanConfig(spec)}
 }
 
 // Connect activates the serial port connection to the lidar.
 // Some devices run scip 1.1 by default. If so, specify scip1IsDefault as true.
 func (h *HokuyoLidar) Connect(scip1IsDefault bool) error {
-	if h.Connected {
+	h.lock()
+	defer h.unlock()
+	if h.state != StateDisconnected {
 		err := errors.New("Lidar is already connected")
 		return err
 	}
@@ -105,8 +123,7 @@ func (h *HokuyoLidar) Connect(scip1IsDefault bool) error {
 		return err
 	}
 	h.options = &options
-	h.serialPort = serialPort
-	h.Connected = true
+	h.attach(serialPort)
 
 	if scip1IsDefault {
 		h.scipTwoCmd()
@@ -115,17 +132,45 @@ func (h *HokuyoLidar) Connect(scip1IsDefault bool) error {
 	return nil
 }
 
+// ConnectPort uses an already open connection to the lidar instead of the
+// serial port, e.g. a TCP bridge or a replayed session.
+func (h *HokuyoLidar) ConnectPort(port io.ReadWriteCloser) error {
+	h.lock()
+	defer h.unlock()
+	if h.state != StateDisconnected {
+		return errors.New("Lidar is already connected")
+	}
+	h.attach(port)
+	return nil
+}
+
+func (h *HokuyoLidar) attach(port io.ReadWriteCloser) {
+	h.serialPort = port
+	h.setState(StateIdle)
+	if h.connections > 0 {
+		h.observer().Reconnect()
+	}
+	h.connections++
+	h.logger().Info("connected", "port", h.portName, "baudrate", h.baudrate)
+}
+
 // Disconnect disables the serial port connection to the lidar.
 func (h *HokuyoLidar) Disconnect() error {
-	if h.Connected {
-		return errors.New("Lidar is already connected")
+	h.lock()
+	defer h.unlock()
+	if h.state == StateDisconnected {
+		return ErrNotConnected
+	}
+	if p, ok := h.serialPort.(interface{ Reset() error }); ok {
+		p.Reset()
 	}
-	h.serialPort.Reset()
 	err := h.serialPort.Close()
 	if err != nil {
 		return err
 	}
-	h.Connected = false
+	h.scanPending = false
+	h.setState(StateDisconnected)
+	h.logger().Info("disconnected", "port", h.portName)
 	return nil
 }
 
@@ -135,75 +180,59 @@ func (h *HokuyoLidar) scipTwoCmd() error {
 	cmd := []byte{'S', 'C', 'I', 'P', '2', '.', '0', lf}
 	err := h.sendCommandBlock(cmd)
 	if err != nil {
-		return fmt.Errorf("Failed to init scip 2.0 protocol: %v", err)
+		return fmt.Errorf("Failed to init scip 2.0 protocol: %w", err)
 	}
 	_, res, err := h.readFixedResponse(13)
 	if err != nil {
-		return fmt.Errorf("ScipTwoCmd: %v", err)
+		return fmt.Errorf("ScipTwoCmd: %w", err)
 	}
 	var buffer bytes.Buffer
-	buffer.Write(res[9:10])
+	buffer.Write(res[8:10])
 	statusCode := buffer.String()
-	log.Printf("%v\n", res)
-	return statusCheck(statusCode)
+	h.logger().Debug("response", "command", "SCIP2.0", "data", string(res))
+	return h.checkStatus("SCIP2.0", statusCode)
 }
 
 // MDMSCmd is a sensor data aquisition command that uses three character encoding or two character encoding.
+// See StartStream.
 func (h *HokuyoLidar) MDMSCmd(three bool, startStep, endStep, clusterCount, scanInterval, numberOfScans int, characters string) error {
-	// stupid proofing the scan
-	ss := strconv.Itoa(startStep)
-	es := strconv.Itoa(endStep)
-	cc := strconv.Itoa(clusterCount)
-	si := strconv.Itoa(scanInterval)
-	ns := strconv.Itoa(numberOfScans)
-
-	zeroPadString(4, &ss)
-	zeroPadString(4, &es)
-	zeroPadString(2, &cc)
-	zeroPadString(1, &si)
-	zeroPadString(2, &ns)
-	if len(characters) > 16 {
-		characters = characters[0:16]
-	}
-
-	var encode byte
-	if three {
-		encode = threeEncoding
-	} else {
-		encode = twoEncoding
-	}
+	return h.StartStream(ScanConfig{startStep, endStep, clusterCount, scanInterval, numberOfScans, encodingOf(three), characters})
+}
 
-	cmd := []byte{mTag, encode}
-	cmd = append(cmd[:], []byte(ss)[:]...)
-	cmd = append(cmd[:], []byte(es)[:]...)
-	cmd = append(cmd[:], []byte(cc)[:]...)
-	cmd = append(cmd[:], []byte(si)[:]...)
-	cmd = append(cmd[:], []byte(ns)[:]...)
-	cmd = append(cmd[:], []byte(characters)[:]...)
-	cmd = append(cmd[:], lf)
+func (h *HokuyoLidar) startStream(c ScanConfig) error {
+	if err := h.require("MD", StateIdle, StateLaserOn); err != nil {
+		return err
+	}
+	if err := c.Validate(h.spec); err != nil {
+		return err
+	}
+	command := string([]byte{mTag, byte(c.Encoding)})
+	cmd := []byte(fmt.Sprintf("%v%04d%04d%02d%01d%02d%v\n", command,
+		c.StartStep, c.EndStep, c.ClusterCount, c.ScanInterval, c.NumberOfScans, c.Characters))
 
 	err := h.sendCommandBlock(cmd)
 	if err != nil {
-		return fmt.Errorf("Encountered error during MD init: %v", err)
+		return fmt.Errorf("Encountered error during MD init: %w", err)
 	}
-	headLen := 21 + len(characters)
+	headLen := 21 + len(c.Characters)
 	_, head, err := h.readFixedResponse(headLen)
 	if err != nil {
-		return fmt.Errorf("Err in scan init: %v", err)
+		return fmt.Errorf("Err in scan init: %w", err)
 	}
 	statusCode := head[headLen-5 : headLen-3]
-	err = statusCheck(string(statusCode))
+	err = h.checkStatus(command, string(statusCode))
 	if err != nil {
 		return err
 	}
 
-	h.startStep = startStep
-	h.endStep = endStep
-	h.clusterCount = clusterCount
-	h.scanInterval = scanInterval
-	h.encodingType = threeEncoding
+	h.scan = c
 	h.headSize = headLen
 	h.requestTag = mTag
+	h.scansLeft = c.NumberOfScans
+	h.streamFrom = h.state
+	h.setState(StateStreaming)
+	h.logger().Info("scanning started", "command", command,
+		"start", c.StartStep, "end", c.EndStep, "cluster", c.ClusterCount, "interval", c.ScanInterval, "scans", c.NumberOfScans)
 	return nil
 }
 
@@ -211,54 +240,41 @@ func (h *HokuyoLidar) MDMSCmd(three bool, startStep, endStep, clusterCount, scan
 // measurement data to  the  host. If the laser is switched off, it should
 // be switched on by sending BM-Command before  the  measurement. Laser
 // should be switched off if necessary by sending QT-Command after
-// measurement is complete.
+// measurement is complete. See RequestScan.
 func (h *HokuyoLidar) GDGSCommand(three bool, startStep, endStep, clusterCount int, characters string) error {
-	ss := strconv.Itoa(startStep)
-	es := strconv.Itoa(endStep)
-	cc := strconv.Itoa(clusterCount)
+	return h.RequestScan(ScanConfig{StartStep: startStep, EndStep: endStep, ClusterCount: clusterCount,
+		Encoding: encodingOf(three), Characters: characters})
+}
 
-	zeroPadString(4, &ss)
-	zeroPadString(4, &es)
-	zeroPadString(2, &cc)
-	if len(characters) > 16 {
-		characters = characters[0:16]
+func (h *HokuyoLidar) requestScan(c ScanConfig) error {
+	if err := h.require("GD", StateLaserOn); err != nil {
+		return err
 	}
-
-	var encode byte
-	if three {
-		encode = threeEncoding
-	} else {
-		encode = twoEncoding
+	if err := c.Validate(h.spec); err != nil {
+		return err
 	}
-
-	cmd := []byte{gTag, encode}
-	cmd = append(cmd[:], []byte(ss)[:]...)
-	cmd = append(cmd[:], []byte(es)[:]...)
-	cmd = append(cmd[:], []byte(cc)[:]...)
-	cmd = append(cmd[:], []byte(characters)[:]...)
-	cmd = append(cmd[:], lf)
+	command := string([]byte{gTag, byte(c.Encoding)})
+	cmd := []byte(fmt.Sprintf("%v%04d%04d%02d%v\n", command, c.StartStep, c.EndStep, c.ClusterCount, c.Characters))
 
 	err := h.sendCommandBlock(cmd)
 	if err != nil {
 		return err
 	}
-	headLen := 18 + len(characters)
+	headLen := 17 + len(c.Characters) // echo and status, the timestamp follows
 	_, head, err := h.readFixedResponse(headLen)
 	if err != nil {
 		return err
 	}
-	statusCode := head[headLen-5 : headLen-3]
-	err = statusCheck(string(statusCode))
+	statusCode := head[headLen-4 : headLen-2]
+	err = h.checkStatus(command, string(statusCode))
 	if err != nil {
 		return err
 	}
 
-	h.startStep = startStep
-	h.endStep = endStep
-	h.clusterCount = clusterCount
-	h.encodingType = threeEncoding
+	h.scan = c
 	h.headSize = headLen
 	h.requestTag = gTag
+	h.scanPending = true
 	return nil
 }
 
@@ -267,8 +283,14 @@ func (h *HokuyoLidar) GDGSCommand(three bool, startStep, endStep, clusterCount i
 // command the laser is initially in off state by default. In this state
 // sensor can not perform the measurement. Laser state can be verified
 // by green LED on the sensor. Laser is off if the LED blinks rapidly
-// and it is ON when LED glows continuously.
+// and it is ON when LED glows continuously. If the laser is already on the
+// returned error matches ErrAlreadyInMode and the lidar is in StateLaserOn.
 func (h *HokuyoLidar) BMCommand(chars string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("BM", StateIdle, StateLaserOn); err != nil {
+		return err
+	}
 	cmd := []byte{bTag, mTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -282,12 +304,21 @@ func (h *HokuyoLidar) BMCommand(chars string) error {
 		return err
 	}
 	statusCode := res[resLen-5 : resLen-3]
-	err = statusCheck(string(statusCode))
+	err = h.checkStatus("BM", string(statusCode))
+	if err == nil || errors.Is(err, ErrAlreadyInMode) {
+		h.setState(StateLaserOn)
+		h.logger().Info("laser switched on")
+	}
 	return err
 }
 
 // QMCommand will switch off the laser disabling sensor’s measurement state.
 func (h *HokuyoLidar) QMCommand(chars string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("QT", StateIdle, StateLaserOn, StateStreaming, StateError); err != nil {
+		return err
+	}
 	cmd := []byte{qTag, tTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -296,7 +327,19 @@ func (h *HokuyoLidar) QMCommand(chars string) error {
 		return err
 	}
 	resLen := len(chars) + 8
-	_, _, err = h.readFixedResponse(resLen) // status is always 0 0
+	if h.state == StateStreaming {
+		// scans sent before the sensor received QT precede the echo
+		err = h.discardUntil(append([]byte{lf}, cmd...))
+		resLen -= len(cmd)
+	}
+	if err == nil {
+		_, _, err = h.readFixedResponse(resLen) // status is always 0 0
+	}
+	if err == nil {
+		h.scanPending = false
+		h.setState(StateIdle)
+		h.logger().Info("laser switched off")
+	}
 	return err
 }
 
@@ -304,6 +347,11 @@ func (h *HokuyoLidar) QMCommand(chars string) error {
 // was switched on. This turns Laser off, sets motor speed and bit rate
 // back to default as well as reset sensor’s internal timer.
 func (h *HokuyoLidar) RSCommand(chars string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("RS", StateIdle, StateLaserOn, StateStreaming, StateError); err != nil {
+		return err
+	}
 	cmd := []byte{rTag, sTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -312,6 +360,11 @@ func (h *HokuyoLidar) RSCommand(chars string) error {
 		return err
 	}
 	_, _, err = h.readFixedResponse(len(chars) + 8) // status is always 0 0
+	if err == nil {
+		h.scanPending = false
+		h.setState(StateIdle)
+		h.logger().Info("sensor reset")
+	}
 	return err
 }
 
@@ -325,6 +378,15 @@ func (h *HokuyoLidar) RSCommand(chars string) error {
 // Control byte: 0 -> adjust mode on, 2 -> time request, 3 -> adjust mode off.
 // int will return time if control is 1, 0 if error or not 1.
 func (h *HokuyoLidar) TMCommand(control byte, chars string) (int, error) {
+	h.lock()
+	defer h.unlock()
+	allowed := []State{StateIdle, StateLaserOn}
+	if control == '1' || control == '2' {
+		allowed = []State{StateTimeAdjust}
+	}
+	if err := h.require("TM", allowed...); err != nil {
+		return 0, err
+	}
 	cmd := []byte{tTag, mTag, control}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -333,18 +395,15 @@ func (h *HokuyoLidar) TMCommand(control byte, chars string) (int, error) {
 		return 0, err
 	}
 	_, head, err := h.readFixedResponse(len(cmd) + 2)
+	if err != nil {
+		return 0, err
+	}
 	headLen := len(head)
-	statusCode := string(head[headLen-2 : headLen-1])
-	switch statusCode {
-	case "01":
-		return 0, errors.New("Invalid Control Code")
-	case "02":
-		return 0, errors.New("Adjust mode on when already on")
-	case "03":
-		return 0, errors.New("Adjust mode off when already off")
-	case "04":
-		return 0, errors.New("Adjust mode off when time requested")
-	default:
+	statusCode := string(head[headLen-2 : headLen])
+	if err := h.checkStatus("TM", statusCode); err != nil {
+		// consume the rest of the response
+		h.readFixedResponse(3)
+		return 0, err
 	}
 	if control == '1' {
 		_, res, err := h.readFixedResponse(9)
@@ -355,6 +414,17 @@ func (h *HokuyoLidar) TMCommand(control byte, chars string) (int, error) {
 		return time, nil
 	}
 	_, _, err = h.readFixedResponse(3)
+	if err == nil {
+		switch control {
+		case '0':
+			h.scanPending = false
+			h.setState(StateTimeAdjust)
+			h.logger().Info("time adjust mode on")
+		case '2':
+			h.setState(StateIdle)
+			h.logger().Info("time adjust mode off")
+		}
+	}
 	return 0, err
 }
 
@@ -369,6 +439,11 @@ func (h *HokuyoLidar) TMCommand(control byte, chars string) (int, error) {
 // 500000 --- 500.0 Kbps
 // 750000 --- 750.0 Kbps.
 func (h *HokuyoLidar) SSCommand(sixCharacterBitRate string, chars string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("SS", StateIdle, StateLaserOn); err != nil {
+		return err
+	}
 	if len(sixCharacterBitRate) != 6 {
 		return errors.New("Invalid bitrate string")
 	}
@@ -385,18 +460,7 @@ func (h *HokuyoLidar) SSCommand(sixCharacterBitRate string, chars string) error
 		return err
 	}
 	statusCode := string(res[len(cmd) : len(cmd)+2])
-	switch statusCode {
-	case "01":
-		return errors.New("Bit rate has non-numeric value")
-	case "02":
-		return errors.New("Invlaid bit rate")
-	case "03":
-		return errors.New("Sensor is already running at defined bit rate")
-	case "04":
-		return errors.New("Not compatible with the sensor model")
-	default:
-	}
-	return nil
+	return h.checkStatus("SS", statusCode)
 }
 
 // HSCommand will switch between high sensitivity and normal sensitivity modes.
@@ -404,6 +468,11 @@ func (h *HokuyoLidar) SSCommand(sixCharacterBitRate string, chars string) error
 // mode. However there may be chances of measurement errors due to strong
 // reflective objects near 22m.
 func (h *HokuyoLidar) HSCommand(highMode bool, chars string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("HS", StateIdle, StateLaserOn); err != nil {
+		return err
+	}
 	var param byte
 	if highMode {
 		param = '1'
@@ -418,49 +487,50 @@ func (h *HokuyoLidar) HSCommand(highMode bool, chars string) error {
 		return err
 	}
 	_, res, err := h.readFixedResponse(len(cmd) + 5)
-	statusCode := string(res[len(cmd) : len(cmd)+2])
-	switch statusCode {
-	case "01":
-		return errors.New("Parameter error")
-	case "02":
-		return errors.New("Already running in set mode")
-	case "03":
-		return errors.New("Incompatible with current sensor model")
-	default:
+	if err != nil {
+		return err
 	}
-	return nil
+	statusCode := string(res[len(cmd) : len(cmd)+2])
+	return h.checkStatus("HS", statusCode)
 }
 
-// CRCommand is used to adjust the sensor’s motor speed.
-func (h *HokuyoLidar) CRCommand(chars string) error {
-	cmd := []byte{cTag, rTag, chars[0], chars[1]}
-	cmd = append(cmd[:], []byte(chars)[:]...)
+// CRCommand is used to adjust the sensor’s motor speed. The speed ratio is
+// two characters, 00 for the default speed and 01 to 10 for the speed
+// levels.
+func (h *HokuyoLidar) CRCommand(speedRatio string) error {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("CR", StateIdle, StateLaserOn); err != nil {
+		return err
+	}
+	if len(speedRatio) != 2 {
+		return errors.New("Invalid speed ratio string")
+	}
+	cmd := []byte{cTag, rTag}
+	cmd = append(cmd[:], []byte(speedRatio)[:]...)
 	cmd = append(cmd, lf)
 	err := h.sendCommandBlock(cmd)
 	if err != nil {
 		return err
 	}
-	_, res, err := h.readFixedResponse(len(cmd) + 6)
+	_, res, err := h.readFixedResponse(len(cmd) + 5)
 	if err != nil {
 		return err
 	}
-	statusCode := string(res[len(cmd)+1 : len(cmd)+3])
-	switch statusCode {
-	case "01":
-		return errors.New("Invalid speed ratio")
-	case "02":
-		return errors.New("Speed ratio out of range")
-	case "03":
-		return errors.New("Motor is already running at defined speed")
-	case "04":
-		return errors.New("Incompatible with current sensor model")
-	default:
-	}
-	return nil
+	statusCode := string(res[len(cmd) : len(cmd)+2])
+	return h.checkStatus("CR", statusCode)
 }
 
 // PPCommand Sensor transmits its specifications on receiving this command.
 func (h *HokuyoLidar) PPCommand(chars string) ([]string, error) {
+	h.lock()
+	defer h.unlock()
+	if h.state == StateStreaming && h.specification != nil {
+		return append([]string{}, h.specification...), nil
+	}
+	if err := h.require("PP", StateIdle, StateLaserOn); err != nil {
+		return nil, err
+	}
 	cmd := []byte{pTag, pTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -472,28 +542,21 @@ func (h *HokuyoLidar) PPCommand(chars string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	stray := []string{}
-	for i := 0; i < 6; i++ {
-		raw := []byte{}
-		var read byte
-		for read != lf {
-			_, res, err := h.readFixedResponse(1)
-			if err != nil {
-				return nil, err
-			}
-			read = res[0]
-			raw = append(raw, res[0])
-		}
-		if raw[0] != lf {
-			rawstr := strings.Split(string(raw), ";")[0]
-			stray = append(stray, rawstr)
-		}
+	stray, err := h.readInfo()
+	if err != nil {
+		return nil, err
 	}
+	h.specification = append([]string{}, stray...)
 	return stray, nil
 }
 
 // IICommand Sensor transmits its running state on receiving this command.
 func (h *HokuyoLidar) IICommand(chars string) ([]string, error) {
+	h.lock()
+	defer h.unlock()
+	if err := h.require("II", StateIdle, StateLaserOn); err != nil {
+		return nil, err
+	}
 	cmd := []byte{iTag, iTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -505,22 +568,9 @@ func (h *HokuyoLidar) IICommand(chars string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	stray := []string{}
-	for i := 0; i < 7; i++ {
-		raw := []byte{}
-		var read byte
-		for read != lf {
-			_, res, err := h.readFixedResponse(1)
-			if err != nil {
-				return nil, err
-			}
-			read = res[0]
-			raw = append(raw, res[0])
-		}
-		if raw[0] != lf {
-			rawstr := strings.Split(string(raw), ";")[0]
-			stray = append(stray, rawstr)
-		}
+	stray, err := h.readInfo()
+	if err != nil {
+		return nil, err
 	}
 	return stray, nil
 }
@@ -528,6 +578,14 @@ func (h *HokuyoLidar) IICommand(chars string) ([]string, error) {
 // VVCommand Sensor transmits version details such as, serial number,
 // firmware version etc on receiving this command.
 func (h *HokuyoLidar) VVCommand(chars string) ([]string, error) {
+	h.lock()
+	defer h.unlock()
+	if h.state == StateStreaming && h.version != nil {
+		return append([]string{}, h.version...), nil
+	}
+	if err := h.require("VV", StateIdle, StateLaserOn); err != nil {
+		return nil, err
+	}
 	cmd := []byte{vTag, vTag}
 	cmd = append(cmd[:], []byte(chars)[:]...)
 	cmd = append(cmd, lf)
@@ -539,71 +597,36 @@ func (h *HokuyoLidar) VVCommand(chars string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	stray := []string{}
-	for i := 0; i < 5; i++ {
-		raw := []byte{}
-		var read byte
-		for read != lf {
-			_, res, err := h.readFixedResponse(1)
-			if err != nil {
-				return nil, err
-			}
-			read = res[0]
-			raw = append(raw, res[0])
-		}
-		if raw[0] != lf {
-			rawstr := strings.Split(string(raw), ";")[0]
-			stray = append(stray, rawstr)
-		}
+	stray, err := h.readInfo()
+	if err != nil {
+		return nil, err
 	}
+	h.version = append([]string{}, stray...)
 	return stray, nil
 }
 
 // GetDistance returns a list of distances and a timestamp
 func (h *HokuyoLidar) GetDistance() ([]int, int, error) {
-	var resLen int
-	if h.requestTag == mTag {
-		resLen = 16
-	} else {
-		resLen = h.headSize - 10
-	}
-	_, _, err := h.readFixedResponse(resLen)
-	if err != nil {
-		return nil, 0, err
-	}
-	_, statusAndJunk, err := h.readFixedResponse(4)
-	if err != nil {
-		return nil, 0, err
-	}
-	statusCode := string(statusAndJunk[0:2])
-	err = statusCheck(statusCode)
-	if err != nil {
-		return nil, 0, err
-	}
-	_, encodedTime, err := h.readFixedResponse(6)
-	timestamp := decode(encodedTime[0:4])
+	h.lock()
+	defer h.unlock()
+	return h.getDistance("GetDistance")
+}
 
-	data := []byte{}
-	for {
-		_, chungus, err := h.readFixedResponse(66) // data plus lf lf
-		if err != nil {
-			return nil, 0, fmt.Errorf("Failed to read data chunk during scan: %v", err)
-		}
-		data = append(data[:], chungus[0:len(chungus)-2]...)
-		dataleft := string(chungus[13:15])
-		if dataleft == "00" {
-			break
-		}
+func (h *HokuyoLidar) getDistance(command string) ([]int, int, error) {
+	if err := h.requireScan(command); err != nil {
+		return nil, 0, err
 	}
-
-	h.readFixedResponse(1) // lf
-
+	start := time.Now()
 	var scanSize int
-	if h.encodingType == threeEncoding {
+	if h.scan.Encoding == ThreeCharEncoding {
 		scanSize = 3
 	} else {
 		scanSize = 2
 	}
+	timestamp, data, err := h.readScan(scanSize)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	distance := []int{}
 	dist := []byte{}
@@ -615,51 +638,24 @@ func (h *HokuyoLidar) GetDistance() ([]int, int, error) {
 			dist = []byte{}
 		}
 	}
+	h.observeScan(start, timestamp, distance)
+	h.scanRead()
 	return distance, timestamp, nil
 }
 
 // GetDistanceAndIntensity returns a list of distances, intensities, and a timestamp
 func (h *HokuyoLidar) GetDistanceAndIntensity() ([]int, []int, int, error) {
-	var resLen int
-	if h.requestTag == 'M' {
-		resLen = int(h.headSize)
-	} else {
-		resLen = int(h.headSize - 10)
-	}
-	_, _, err := h.readFixedResponse(resLen)
-	if err != nil {
-		return nil, nil, 0, fmt.Errorf("Failed to read reponse header: %v", err)
-	}
-	_, statusAndJunk, err := h.readFixedResponse(4)
-	if err != nil {
-		return nil, nil, 0, fmt.Errorf("Failed to read status of scan: %v", err)
-	}
-	statusCode := string(statusAndJunk[0:2])
-	err = statusCheck(statusCode)
-	if err != nil {
+	h.lock()
+	defer h.unlock()
+	if err := h.requireScan("GetDistanceAndIntensity"); err != nil {
 		return nil, nil, 0, err
 	}
-	_, encodedTime, err := h.readFixedResponse(6)
+	start := time.Now()
+	timestamp, data, err := h.readScan(6) // distance and intensity
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("Failed to read timestamp: %v", err)
-	}
-	timestamp := decode(encodedTime[0:4])
-
-	data := []byte{}
-	for {
-		_, chungus, err := h.readFixedResponse(66) // data plus lf lf
-		if err != nil {
-			return nil, nil, 0, fmt.Errorf("Failed to read data chunk during scan: %v", err)
-		}
-		data = append(data[:], chungus[0:len(chungus)-2]...)
-		dataleft := string(chungus[13:15])
-		if dataleft == "00" {
-			break
-		}
+		return nil, nil, 0, err
 	}
 
-	h.readFixedResponse(1) // lf
-
 	distance := []int{}
 	intensity := []int{}
 	count := 1
@@ -678,73 +674,255 @@ func (h *HokuyoLidar) GetDistanceAndIntensity() ([]int, []int, int, error) {
 		}
 	}
 
+	if h.intensityModel != nil {
+		for i := range intensity {
+			if i < len(distance) && distance[i] >= h.spec.MinDistance {
+				intensity[i] = h.intensityModel.Correct(intensity[i], distance[i])
+			}
+		}
+	}
+
+	h.observeScan(start, timestamp, distance)
+	h.scanRead()
 	return distance, intensity, timestamp, nil
 }
 
+// readScan reads a scan response of pointSize bytes per point and returns
+// its timestamp and data. The echo and status of a GD/GS response were
+// read by requestScan, an MD/MS stream repeats them for every scan.
+func (h *HokuyoLidar) readScan(pointSize int) (int, []byte, error) {
+	var sumErr error
+	if h.requestTag == mTag {
+		_, _, err := h.readFixedResponse(h.headSize - 5) // echo of the command
+		if err != nil {
+			return 0, nil, fmt.Errorf("Failed to read reponse header: %w", err)
+		}
+		_, statusAndJunk, err := h.readFixedResponse(4)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Failed to read status of scan: %w", err)
+		}
+		sumErr = h.verify(statusAndJunk[0:2], statusAndJunk[2])
+		err = h.checkStatus(h.scanCommand(), string(statusAndJunk[0:2]))
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	_, encodedTime, err := h.readFixedResponse(6)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Failed to read timestamp: %w", err)
+	}
+	if err := h.verify(encodedTime[0:4], encodedTime[4]); sumErr == nil {
+		sumErr = err
+	}
+	timestamp := decode(encodedTime[0:4])
+
+	left := h.scan.points() * pointSize
+	data := make([]byte, 0, left)
+	for left > 0 {
+		size := segmentSize - 1
+		if left < size {
+			size = left
+		}
+		_, chungus, err := h.readFixedResponse(size + 2) // data plus sum and lf
+		if err != nil {
+			return 0, nil, fmt.Errorf("Failed to read data chunk during scan: %w", err)
+		}
+		if err := h.verify(chungus[0:size], chungus[size]); sumErr == nil {
+			sumErr = err
+		}
+		data = append(data, chungus[0:size]...)
+		left -= size
+	}
+
+	h.readFixedResponse(1) // lf
+	if sumErr != nil {
+		return 0, nil, sumErr
+	}
+	return timestamp, data, nil
+}
+
+// discardUntil reads and drops the input up to and including marker.
+func (h *HokuyoLidar) discardUntil(marker []byte) error {
+	// a stream is only interrupted after a whole scan, at a line start
+	last := []byte{lf}
+	for !bytes.HasSuffix(last, marker) {
+		_, res, err := h.readFixedResponse(1)
+		if err != nil {
+			return err
+		}
+		last = append(last, res[0])
+		if len(last) > len(marker) {
+			last = last[1:]
+		}
+	}
+	return nil
+}
+
+// readInfo reads the lines of a VV, PP or II response up to the empty
+// line ending it and strips their checksums.
+func (h *HokuyoLidar) readInfo() ([]string, error) {
+	lines := []string{}
+	for {
+		raw := []byte{}
+		var read byte
+		for read != lf {
+			_, res, err := h.readFixedResponse(1)
+			if err != nil {
+				return nil, err
+			}
+			read = res[0]
+			raw = append(raw, res[0])
+		}
+		if raw[0] == lf {
+			return lines, nil
+		}
+		lines = append(lines, strings.Split(string(raw), ";")[0])
+	}
+}
+
 func (h *HokuyoLidar) sendCommandBlock(req []byte) error {
+	if h.state == StateDisconnected {
+		return ErrNotConnected
+	}
 	size := len(req)
+	h.logger().Debug("command sent", "command", string(req[:2]), "request", strings.TrimSuffix(string(req), "\n"))
 	asize, err := h.serialPort.Write(req)
 	if size != asize {
+		h.logger().Error("short write", "command", string(req[:2]), "expected", size, "written", asize)
+		h.setState(StateError)
 		return errors.New("Failed to send all request bytes")
 	}
+	if err != nil {
+		h.setState(StateError)
+	}
 	return err
 }
 
 func (h *HokuyoLidar) readFixedResponse(size int) (int, []byte, error) {
 	res := make([]byte, size)
-	read, err := h.serialPort.Read(res)
-	if read != size {
-		return read, nil, errors.New("Failed to read all expected bytes")
+	if h.state == StateDisconnected {
+		return 0, nil, ErrNotConnected
 	}
+	read, err := h.serialPort.Read(res)
 	if err != nil {
-		return 0, nil, errors.New("Failed to read from serial port")
+		h.logger().Error("serial read failed", "error", err)
+		h.setState(StateError)
+		return 0, nil, fmt.Errorf("Failed to read from serial port: %w", err)
+	}
+	if read != size {
+		h.observer().Timeout()
+		h.logger().Warn("short read", "expected", size, "read", read)
+		h.setState(StateError)
+		return read, nil, fmt.Errorf("Read %d of %d expected bytes: %w", read, size, ErrTimeout)
 	}
-	return read, res, err
+	return read, res, nil
 }
 
-func statusCheck(code string) error {
+// checkStatus reports the status code of command to the metrics and
+// checks it.
+func (h *HokuyoLidar) checkStatus(command, code string) error {
+	h.status(command, code)
+	return statusError(command, code)
+}
+
+// status reports the status code of a response to the metrics and the
+// logger.
+func (h *HokuyoLidar) status(command, code string) {
+	h.observer().Status(command, code)
 	if code == "00" || code == "99" {
-		return nil
-	} else if _, ok := healthStatus[code]; ok {
-		return fmt.Errorf("A known error has occured: %v: %v", code, healthStatus[code])
+		h.logger().Debug("response", "command", command, "status", code)
 	} else {
-		return fmt.Errorf("An unknown error has occured: %v", code)
+		h.logger().Warn("response", "command", command, "status", code)
+	}
+}
+
+// scanRead ends a stream with a fixed number of scans after its last scan.
+func (h *HokuyoLidar) scanRead() {
+	h.scanPending = false
+	if h.state != StateStreaming || h.scansLeft == 0 {
+		return
+	}
+	h.scansLeft--
+	if h.scansLeft == 0 {
+		h.setState(h.streamFrom)
+	}
+}
+
+// requireScan checks that a scan response is expected.
+func (h *HokuyoLidar) requireScan(command string) error {
+	switch {
+	case h.state == StateStreaming, h.state == StateLaserOn && h.scanPending:
+		return nil
+	case h.state == StateIdle, h.state == StateLaserOn:
+		return ErrNoScan
 	}
+	return &TransitionError{command, h.state}
+}
+
+// scanCommand names the request answered by GetDistance.
+func (h *HokuyoLidar) scanCommand() string {
+	return string([]byte{h.requestTag, byte(h.scan.Encoding)})
+}
+
+func (h *HokuyoLidar) observeScan(start time.Time, timestamp int, distances []int) {
+	invalid := 0
+	for _, d := range distances {
+		if d < DMIN {
+			invalid++
+		}
+	}
+	var period time.Duration
+	if h.requestTag == mTag {
+		period = h.scanPeriod()
+	}
+	h.observer().ScanRead(ScanStats{
+		Latency:   time.Since(start),
+		Timestamp: timestamp,
+		Period:    period,
+		Points:    len(distances),
+		Invalid:   invalid,
+	})
 }
 
 // DataToCartesian converts a distance array from a scan into an array of points.
 func (h *HokuyoLidar) DataToCartesian(distances []int) []mgl64.Vec2 {
+	h.lock()
+	defer h.unlock()
 	coords := []mgl64.Vec2{}
-	step := h.step()
-	radians := math.Pi / 180.0
+	start, step := h.startAngle(), h.angularStep()
 	for i, v := range distances {
-		if v < 20 {
+		if v < h.spec.MinDistance {
 			v = 0
 		}
-		theta := float64(angleMin) + float64(i)*step
-		coords = append(coords, mgl64.Vec2{float64(v) * math.Cos(theta*radians), float64(v) * math.Sin(theta*radians)})
+		theta := start + float64(i)*step
+		coords = append(coords, mgl64.Vec2{float64(v) * math.Cos(theta), float64(v) * math.Sin(theta)})
 	}
 	return coords
 }
 
-func (h *HokuyoLidar) step() float64 {
-	step := 360.0 / float64(ARES*h.clusterCount)
-	return step * float64(h.scanInterval+1.0)
+// StartAngle returns the bearing of the first point of the current scan in
+// radians, as used by DataToCartesian. Clustered points lie in the middle
+// of their steps.
+func (h *HokuyoLidar) StartAngle() float64 {
+	h.lock()
+	defer h.unlock()
+	return h.startAngle()
 }
 
-func zeroPadString(desiredLen int, str *string) {
-	l := len(*str)
-	if l > desiredLen {
-		*str = (*str)[0:desiredLen] // trim the string to the desired length
-	} else {
-		for {
-			if desiredLen != len(*str) {
-				*str = "0" + (*str)
-			} else {
-				break
-			}
-		}
-	}
+// AngularStep returns the angle between two consecutive points of the
+// current scan in radians.
+func (h *HokuyoLidar) AngularStep() float64 {
+	h.lock()
+	defer h.unlock()
+	return h.angularStep()
+}
+
+func (h *HokuyoLidar) startAngle() float64 {
+	return h.spec.StepToRadians(h.scan.StartStep) + float64(h.scan.cluster()-1)/2*h.spec.StepAngle()
+}
+
+func (h *HokuyoLidar) angularStep() float64 {
+	return float64(h.scan.cluster()) * h.spec.StepAngle()
 }
 
 func decode(encoded []byte) int {
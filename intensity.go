@@ -0,0 +1,268 @@
+package gohokuyolidar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IntensityModel corrects raw intensities for the distance and incidence
+// angle of the target. The raw intensity of a surface is modelled as
+// reflectivity * f(d) * cos(incidence)^k, where f is a polynomial in the
+// distance in meters. Corrected intensities are scaled to what the sensor
+// would report at the reference distance and normal incidence.
+type IntensityModel struct {
+	Serial            string    `json:"serial"`
+	MinDistance       int       `json:"min_distance"`       // millimeters, smaller distances are error codes
+	ReferenceDistance float64   `json:"reference_distance"` // millimeters
+	Coefficients      []float64 `json:"coefficients"`       // of f, lowest degree first
+	IncidenceExponent float64   `json:"incidence_exponent"` // k
+}
+
+func (m *IntensityModel) response(distance float64) float64 {
+	d := distance / 1000.0
+	sum, pow := 0.0, 1.0
+	for _, c := range m.Coefficients {
+		sum += c * pow
+		pow *= d
+	}
+	return sum
+}
+
+// Correct returns the intensity of a return at the given distance as if it
+// had been measured at the reference distance.
+func (m *IntensityModel) Correct(intensity, distance int) int {
+	return m.CorrectWithIncidence(intensity, distance, 0)
+}
+
+// CorrectWithIncidence also removes the effect of the incidence angle in
+// radians, for callers that know the surface normal.
+func (m *IntensityModel) CorrectWithIncidence(intensity, distance int, incidence float64) int {
+	f := m.response(float64(distance))
+	if f <= 0 || distance < m.MinDistance || distance <= 0 {
+		return intensity
+	}
+	scale := m.response(m.ReferenceDistance) / f
+	if c := math.Cos(incidence); m.IncidenceExponent != 0 && c > 0.1 {
+		scale /= math.Pow(c, m.IncidenceExponent)
+	}
+	return int(math.Round(float64(intensity) * scale))
+}
+
+// IntensitySample is one measurement of the calibration target.
+type IntensitySample struct {
+	Distance  float64 // millimeters
+	Intensity float64
+	Incidence float64 // radians, 0 when facing the sensor
+}
+
+// IntensityCalibrator collects measurements of a target of constant
+// reflectivity at several distances and fits an IntensityModel to them.
+type IntensityCalibrator struct {
+	Samples []IntensitySample
+	// MinDistance is the smallest valid distance of the sensor in
+	// millimeters, see Spec.
+	MinDistance int
+}
+
+// NewIntensityCalibrator creates a calibrator for a sensor.
+func NewIntensityCalibrator(spec Spec) *IntensityCalibrator {
+	return &IntensityCalibrator{MinDistance: spec.MinDistance}
+}
+
+// AddTarget records the mean distance and intensity of the steps between
+// startIndex and endIndex of a scan from GetDistanceAndIntensity, where the
+// target is seen at the given incidence angle.
+func (c *IntensityCalibrator) AddTarget(distances, intensities []int, startIndex, endIndex int, incidence float64) error {
+	if startIndex < 0 || endIndex >= len(distances) || endIndex >= len(intensities) || startIndex > endIndex {
+		return errors.New("Target steps are out of range")
+	}
+	var d, i float64
+	n := 0
+	for k := startIndex; k <= endIndex; k++ {
+		if distances[k] < c.MinDistance || distances[k] <= 0 {
+			continue
+		}
+		d += float64(distances[k])
+		i += float64(intensities[k])
+		n++
+	}
+	if n == 0 {
+		return errors.New("Target has no valid returns")
+	}
+	c.Samples = append(c.Samples, IntensitySample{d / float64(n), i / float64(n), incidence})
+	return nil
+}
+
+// Fit fits a model of the given polynomial degree. Samples at normal
+// incidence determine the range response, oblique samples the incidence
+// exponent.
+func (c *IntensityCalibrator) Fit(serial string, degree int, referenceDistance float64) (*IntensityModel, error) {
+	normal := []IntensitySample{}
+	oblique := []IntensitySample{}
+	for _, s := range c.Samples {
+		if math.Abs(s.Incidence) < 1e-3 {
+			normal = append(normal, s)
+		} else {
+			oblique = append(oblique, s)
+		}
+	}
+	if len(normal) <= degree {
+		return nil, fmt.Errorf("Need more than %d samples at normal incidence, got %d", degree, len(normal))
+	}
+
+	// least squares polynomial through the normal equations
+	n := degree + 1
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for _, s := range normal {
+		d := s.Distance / 1000.0
+		pows := make([]float64, 2*n)
+		pows[0] = 1
+		for k := 1; k < len(pows); k++ {
+			pows[k] = pows[k-1] * d
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a[i][j] += pows[i+j]
+			}
+			a[i][n] += pows[i] * s.Intensity
+		}
+	}
+	coefficients, err := solveLinear(a)
+	if err != nil {
+		return nil, err
+	}
+	m := &IntensityModel{Serial: serial, MinDistance: c.MinDistance, ReferenceDistance: referenceDistance, Coefficients: coefficients}
+
+	// log(I / f(d)) = k * log(cos(incidence)), fitted through the origin
+	var num, den float64
+	for _, s := range oblique {
+		f := m.response(s.Distance)
+		c := math.Cos(s.Incidence)
+		if f <= 0 || s.Intensity <= 0 || c <= 0.1 {
+			continue
+		}
+		x := math.Log(c)
+		num += x * math.Log(s.Intensity/f)
+		den += x * x
+	}
+	if den > 0 {
+		m.IncidenceExponent = num / den
+	}
+	return m, nil
+}
+
+// solveLinear solves an augmented n by n+1 system with gaussian elimination.
+func solveLinear(a [][]float64) ([]float64, error) {
+	n := len(a)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errors.New("Calibration samples do not determine the model")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			f := a[row][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = a[i][n] / a[i][i]
+	}
+	return x, nil
+}
+
+// checkSerial rejects serial numbers that can't be used as a file name,
+// the serial is reported by the sensor.
+func checkSerial(serial string) error {
+	if serial == "" {
+		return errors.New("Intensity model has no serial number")
+	}
+	if strings.ContainsAny(serial, `/\`) || strings.Contains(serial, "..") {
+		return fmt.Errorf("Invalid serial number %q", serial)
+	}
+	return nil
+}
+
+// SaveIntensityModel writes the model to dir/<serial>.json.
+func SaveIntensityModel(dir string, m *IntensityModel) error {
+	if err := checkSerial(m.Serial); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, m.Serial+".json"), data, 0644)
+}
+
+// LoadIntensityModel reads the model of a sensor from dir/<serial>.json.
+func LoadIntensityModel(dir, serial string) (*IntensityModel, error) {
+	if err := checkSerial(serial); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, serial+".json"))
+	if err != nil {
+		return nil, err
+	}
+	m := &IntensityModel{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Invalid intensity model for %v: %v", serial, err)
+	}
+	return m, nil
+}
+
+// SerialNumber returns the serial number reported by the VV command.
+func (h *HokuyoLidar) SerialNumber() (string, error) {
+	info, err := h.VVCommand("")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range info {
+		if strings.HasPrefix(line, "SERI:") {
+			return strings.TrimPrefix(line, "SERI:"), nil
+		}
+	}
+	return "", errors.New("Sensor did not report a serial number")
+}
+
+// SetIntensityModel makes GetDistanceAndIntensity return corrected
+// intensities. A nil model returns the raw intensities.
+func (h *HokuyoLidar) SetIntensityModel(m *IntensityModel) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.intensityModel = m
+}
+
+// LoadIntensityCalibration looks up the serial number of the connected
+// sensor and applies its model from dir.
+func (h *HokuyoLidar) LoadIntensityCalibration(dir string) error {
+	serial, err := h.SerialNumber()
+	if err != nil {
+		return err
+	}
+	m, err := LoadIntensityModel(dir, serial)
+	if err != nil {
+		return err
+	}
+	h.SetIntensityModel(m)
+	return nil
+}
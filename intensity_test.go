@@ -0,0 +1,73 @@
+package gohokuyolidar
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestIntensityCalibration(t *testing.T) {
+	// a target whose intensity falls off with distance and incidence
+	raw := func(d, incidence float64) float64 {
+		dm := d / 1000.0
+		return (9000 - 2000*dm + 150*dm*dm) * math.Pow(math.Cos(incidence), 1.5)
+	}
+	c := NewIntensityCalibrator(DefaultSpec())
+	for d := 500.0; d <= 4000; d += 500 {
+		c.Samples = append(c.Samples, IntensitySample{d, raw(d, 0), 0})
+	}
+	c.Samples = append(c.Samples, IntensitySample{2000, raw(2000, 0.6), 0.6})
+
+	m, err := c.Fit("H0000001", 2, 1000)
+	if err != nil {
+		t.Fatalf("Fit failed: %v\n", err)
+	}
+	if math.Abs(m.IncidenceExponent-1.5) > 1e-3 {
+		t.Fatalf("Expected an incidence exponent of 1.5, got %v\n", m.IncidenceExponent)
+	}
+	want := raw(1000, 0)
+	for _, d := range []int{800, 2500, 3900} {
+		got := m.Correct(int(raw(float64(d), 0)), d)
+		if math.Abs(float64(got)-want) > 2 {
+			t.Fatalf("Expected %v at %vmm after correction, got %v\n", want, d, got)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := SaveIntensityModel(dir, m); err != nil {
+		t.Fatalf("SaveIntensityModel failed: %v\n", err)
+	}
+	loaded, err := LoadIntensityModel(dir, "H0000001")
+	if err != nil || len(loaded.Coefficients) != 3 || loaded.MinDistance != DMIN {
+		t.Fatalf("Expected to load the saved model, got %v %v\n", loaded, err)
+	}
+	for _, serial := range []string{"../H0000001", "a/b", `a\b`, ".."} {
+		if _, err := LoadIntensityModel(dir, serial); err == nil || os.IsNotExist(err) {
+			t.Fatalf("Expected serial %q to be rejected, got %v\n", serial, err)
+		}
+		m.Serial = serial
+		if err := SaveIntensityModel(dir, m); err == nil {
+			t.Fatalf("Expected serial %q to be rejected\n", serial)
+		}
+	}
+}
+
+func TestIntensityMinDistance(t *testing.T) {
+	spec := DefaultSpec()
+	spec.MinDistance = 100
+	c := NewIntensityCalibrator(spec)
+	// steps 0 and 1 are closer than the sensor measures
+	if err := c.AddTarget([]int{60, 90, 1000, 1200}, []int{1, 1, 500, 700}, 0, 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Samples[0]; s.Distance != 1100 || s.Intensity != 600 {
+		t.Fatalf("Expected the mean of the valid steps, got %+v\n", s)
+	}
+	if err := c.AddTarget([]int{60, 90}, []int{1, 1}, 0, 1, 0); err == nil {
+		t.Fatal("Expected a target without valid returns to be rejected")
+	}
+	m := &IntensityModel{MinDistance: 100, ReferenceDistance: 1000, Coefficients: []float64{1, 1}}
+	if got := m.Correct(50, 60); got != 50 {
+		t.Fatalf("Expected an invalid distance to keep its intensity, got %v\n", got)
+	}
+}
@@ -0,0 +1,98 @@
+package legs
+
+import (
+	"io"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/Dolphindalt/GoHokuyoLidar/segment"
+)
+
+// Evaluation counts the detections of a labelled recording.
+type Evaluation struct {
+	Scans          int
+	TruePositives  int
+	FalsePositives int
+	FalseNegatives int
+}
+
+// Precision returns the fraction of detections that were legs.
+func (e Evaluation) Precision() float64 {
+	if e.TruePositives+e.FalsePositives == 0 {
+		return 0
+	}
+	return float64(e.TruePositives) / float64(e.TruePositives+e.FalsePositives)
+}
+
+// Recall returns the fraction of legs that were detected.
+func (e Evaluation) Recall() float64 {
+	if e.TruePositives+e.FalseNegatives == 0 {
+		return 0
+	}
+	return float64(e.TruePositives) / float64(e.TruePositives+e.FalseNegatives)
+}
+
+// Evaluate runs the detector on a recording whose scans carry the leg
+// positions as labels. A detection within matchDistance millimeters of an
+// unmatched label counts as a true positive.
+func Evaluate(r io.Reader, d *Detector, matchDistance float64) (Evaluation, error) {
+	e := Evaluation{}
+	reader := recording.NewReader(r)
+	for {
+		scan, err := reader.Read()
+		if err == io.EOF {
+			return e, nil
+		}
+		if err != nil {
+			return e, err
+		}
+		e.Scans++
+		detected, _ := d.Detect(scan.Points(), scan.Step)
+		matched := make([]bool, len(scan.Labels))
+		for _, leg := range detected {
+			best, bestDist := -1, matchDistance
+			for i, label := range scan.Labels {
+				if dist := leg.Position.Sub(label).Len(); !matched[i] && dist <= bestDist {
+					best, bestDist = i, dist
+				}
+			}
+			if best >= 0 {
+				matched[best] = true
+				e.TruePositives++
+			} else {
+				e.FalsePositives++
+			}
+		}
+		for _, m := range matched {
+			if !m {
+				e.FalseNegatives++
+			}
+		}
+	}
+}
+
+// Samples extracts training samples from a labelled recording. Every
+// segment whose centroid lies within matchDistance millimeters of a label
+// is a leg sample, every other segment a negative one.
+func Samples(r io.Reader, config segment.Config, matchDistance float64) ([]Sample, error) {
+	samples := []Sample{}
+	reader := recording.NewReader(r)
+	for {
+		scan, err := reader.Read()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+		for _, c := range segment.Segment(scan.Points(), scan.Step, config) {
+			leg := false
+			for _, label := range scan.Labels {
+				if c.Centroid.Sub(label).Len() <= matchDistance {
+					leg = true
+					break
+				}
+			}
+			samples = append(samples, Sample{Features: Extract(c), Leg: leg})
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package legs
+
+import (
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/features"
+	"github.com/Dolphindalt/GoHokuyoLidar/segment"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Features are the geometric properties of a segment used to classify it.
+type Features struct {
+	Points      int     // number of points
+	Width       float64 // distance between the first and last point in mm
+	Linearity   float64 // RMS distance of the points to their best line in mm
+	Circularity float64 // RMS distance of the points to their best circle in mm
+	Radius      float64 // radius of the best circle in mm
+}
+
+// Extract computes the features of a cluster.
+func Extract(c segment.Cluster) Features {
+	f := Features{Points: len(c.Points), Width: c.Extent}
+	if len(c.Points) < 3 {
+		return f
+	}
+	idx := make([]int, len(c.Points))
+	for i := range idx {
+		idx[i] = i
+	}
+	line := features.FitLine(c.Points, idx)
+	sum := 0.0
+	for _, p := range c.Points {
+		d := line.Distance(p)
+		sum += d * d
+	}
+	f.Linearity = math.Sqrt(sum / float64(len(c.Points)))
+
+	center, radius := fitCircle(c.Points)
+	sum = 0.0
+	for _, p := range c.Points {
+		d := p.Sub(center).Len() - radius
+		sum += d * d
+	}
+	f.Circularity = math.Sqrt(sum / float64(len(c.Points)))
+	f.Radius = radius
+	return f
+}
+
+// fitCircle fits a circle with the algebraic least squares method of Kasa.
+func fitCircle(points []mgl64.Vec2) (mgl64.Vec2, float64) {
+	var mean mgl64.Vec2
+	for _, p := range points {
+		mean = mean.Add(p)
+	}
+	mean = mean.Mul(1.0 / float64(len(points)))
+
+	var suu, svv, suv, suuu, svvv, suvv, svuu float64
+	for _, p := range points {
+		u, v := p[0]-mean[0], p[1]-mean[1]
+		suu += u * u
+		svv += v * v
+		suv += u * v
+		suuu += u * u * u
+		svvv += v * v * v
+		suvv += u * v * v
+		svuu += v * u * u
+	}
+	det := suu*svv - suv*suv
+	if math.Abs(det) < 1e-9 {
+		return mean, math.Inf(1)
+	}
+	bu := 0.5 * (suuu + suvv)
+	bv := 0.5 * (svvv + svuu)
+	uc := (bu*svv - bv*suv) / det
+	vc := (bv*suu - bu*suv) / det
+	n := float64(len(points))
+	radius := math.Sqrt(uc*uc + vc*vc + (suu+svv)/n)
+	return mgl64.Vec2{uc + mean[0], vc + mean[1]}, radius
+}
@@ -0,0 +1,214 @@
+// Package legs detects human legs in scans taken at leg height and pairs
+// them into person candidates.
+package legs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/segment"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Thresholds is the decision rule of the classifier. A segment is a leg if
+// all of its features lie inside the bounds.
+type Thresholds struct {
+	MinPoints      int
+	MinWidth       float64
+	MaxWidth       float64
+	MinLinearity   float64 // legs are rounded, walls are straight
+	MaxCircularity float64
+	MinRadius      float64
+	MaxRadius      float64
+}
+
+// DefaultThresholds returns hand tuned bounds for adult legs.
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		MinPoints:      3,
+		MinWidth:       50.0,
+		MaxWidth:       250.0,
+		MinLinearity:   2.0,
+		MaxCircularity: 15.0,
+		MinRadius:      30.0,
+		MaxRadius:      150.0,
+	}
+}
+
+// Classify reports whether the features describe a leg.
+func (t Thresholds) Classify(f Features) bool {
+	return f.Points >= t.MinPoints &&
+		f.Width >= t.MinWidth && f.Width <= t.MaxWidth &&
+		f.Linearity >= t.MinLinearity &&
+		f.Circularity <= t.MaxCircularity &&
+		f.Radius >= t.MinRadius && f.Radius <= t.MaxRadius
+}
+
+// Sample is a labelled feature vector used for training.
+type Sample struct {
+	Features Features
+	Leg      bool
+}
+
+// Train derives thresholds from labelled samples. Each bound is placed at
+// the given quantile of the leg samples, e.g. 0.05 keeps 90% of the legs
+// inside every bound. The quantile must be between 0 and 0.5. Bounds that
+// reject no negative sample are relaxed to the extremes of the legs.
+func Train(samples []Sample, quantile float64) (Thresholds, error) {
+	if !(quantile >= 0 && quantile <= 0.5) {
+		return Thresholds{}, fmt.Errorf("Quantile %v is outside 0-0.5", quantile)
+	}
+	legs := []Features{}
+	others := []Features{}
+	for _, s := range samples {
+		if s.Leg {
+			legs = append(legs, s.Features)
+		} else {
+			others = append(others, s.Features)
+		}
+	}
+	t := DefaultThresholds()
+	if len(legs) == 0 {
+		return t, nil
+	}
+	pick := func(get func(Features) float64) (float64, float64) {
+		values := make([]float64, len(legs))
+		for i, f := range legs {
+			values[i] = get(f)
+		}
+		sort.Float64s(values)
+		lo := values[int(quantile*float64(len(values)-1))]
+		hi := values[int(math.Ceil((1-quantile)*float64(len(values)-1)))]
+		return lo, hi
+	}
+	t.MinWidth, t.MaxWidth = pick(func(f Features) float64 { return f.Width })
+	t.MinLinearity, _ = pick(func(f Features) float64 { return f.Linearity })
+	_, t.MaxCircularity = pick(func(f Features) float64 { return f.Circularity })
+	t.MinRadius, t.MaxRadius = pick(func(f Features) float64 { return f.Radius })
+	minPoints, _ := pick(func(f Features) float64 { return float64(f.Points) })
+	t.MinPoints = int(minPoints)
+
+	// a bound that rejects no negative only costs recall
+	rejects := func(test func(Features) bool) bool {
+		for _, f := range others {
+			if test(f) {
+				return true
+			}
+		}
+		return false
+	}
+	if !rejects(func(f Features) bool { return f.Linearity < t.MinLinearity }) {
+		t.MinLinearity = 0
+	}
+	if !rejects(func(f Features) bool { return f.Circularity > t.MaxCircularity }) {
+		t.MaxCircularity = math.Inf(1)
+	}
+	return t, nil
+}
+
+// Leg is a detected leg.
+type Leg struct {
+	Position mgl64.Vec2 // center of the fitted circle, or the centroid
+	Features Features
+	Cluster  segment.Cluster
+}
+
+// Person is a pair of legs close to each other, or a single leg whose
+// partner is occluded.
+type Person struct {
+	Position mgl64.Vec2
+	Legs     []Leg
+}
+
+// Config holds the parameters of the detector.
+type Config struct {
+	Segment    segment.Config
+	Thresholds Thresholds
+	// MaxLegDistance is the largest distance in millimeters between the two
+	// legs of a person.
+	MaxLegDistance float64
+	// SingleLegPersons reports unpaired legs as persons.
+	SingleLegPersons bool
+}
+
+// DefaultConfig returns parameters suited for the URG-04LX at leg height.
+func DefaultConfig() Config {
+	seg := segment.DefaultConfig()
+	seg.MinPoints = 3
+	return Config{
+		Segment:          seg,
+		Thresholds:       DefaultThresholds(),
+		MaxLegDistance:   500.0,
+		SingleLegPersons: true,
+	}
+}
+
+// Detector finds legs and persons in scans.
+type Detector struct {
+	config Config
+}
+
+// NewDetector creates a detector.
+func NewDetector(config Config) *Detector {
+	return &Detector{config: config}
+}
+
+// Detect classifies the segments of a scan, as returned by DataToCartesian,
+// and pairs the legs into persons. step is the angle between points in
+// radians.
+func (d *Detector) Detect(points []mgl64.Vec2, step float64) ([]Leg, []Person) {
+	legs := []Leg{}
+	for _, c := range segment.Segment(points, step, d.config.Segment) {
+		f := Extract(c)
+		if !d.config.Thresholds.Classify(f) {
+			continue
+		}
+		pos := c.Centroid
+		center, r := fitCircle(c.Points)
+		if !math.IsInf(r, 1) && r <= d.config.Thresholds.MaxRadius {
+			pos = center
+		}
+		legs = append(legs, Leg{Position: pos, Features: f, Cluster: c})
+	}
+	return legs, d.pair(legs)
+}
+
+// pair greedily joins the closest legs into persons.
+func (d *Detector) pair(legs []Leg) []Person {
+	type candidate struct {
+		a, b int
+		dist float64
+	}
+	candidates := []candidate{}
+	for a := range legs {
+		for b := a + 1; b < len(legs); b++ {
+			dist := legs[a].Position.Sub(legs[b].Position).Len()
+			if dist <= d.config.MaxLegDistance {
+				candidates = append(candidates, candidate{a, b, dist})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+
+	paired := make([]bool, len(legs))
+	persons := []Person{}
+	for _, c := range candidates {
+		if paired[c.a] || paired[c.b] {
+			continue
+		}
+		paired[c.a], paired[c.b] = true, true
+		persons = append(persons, Person{
+			Position: legs[c.a].Position.Add(legs[c.b].Position).Mul(0.5),
+			Legs:     []Leg{legs[c.a], legs[c.b]},
+		})
+	}
+	if d.config.SingleLegPersons {
+		for i, l := range legs {
+			if !paired[i] {
+				persons = append(persons, Person{Position: l.Position, Legs: []Leg{l}})
+			}
+		}
+	}
+	return persons
+}
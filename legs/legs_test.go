@@ -0,0 +1,80 @@
+package legs
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+const step = 2 * math.Pi / 1024
+
+// scene renders a scan of a straight wall at x = 3000 with two legs of
+// radius 60 standing in front of it.
+func scene(legs []mgl64.Vec2) recording.Scan {
+	s := recording.Scan{StartAngle: -1.0, Step: step, Labels: legs}
+	for i := 0; i < 340; i++ {
+		theta := s.Angle(i)
+		dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+		r := 3000 / dir[0]
+		for _, c := range legs {
+			b := c.Dot(dir)
+			if disc := b*b - c.Dot(c) + 60*60; disc > 0 {
+				r = math.Min(r, b-math.Sqrt(disc))
+			}
+		}
+		s.Distances = append(s.Distances, int(r))
+	}
+	return s
+}
+
+func TestDetect(t *testing.T) {
+	legs := []mgl64.Vec2{{1500, 100}, {1500, 350}}
+	s := scene(legs)
+	detected, persons := NewDetector(DefaultConfig()).Detect(s.Points(), step)
+	if len(detected) != 2 {
+		t.Fatalf("Expected 2 legs, got %v\n", len(detected))
+	}
+	if len(persons) != 1 || len(persons[0].Legs) != 2 {
+		t.Fatalf("Expected 1 person with 2 legs, got %v\n", persons)
+	}
+	if d := persons[0].Position.Sub(mgl64.Vec2{1500, 225}).Len(); d > 60 {
+		t.Fatalf("Expected the person near (1500, 225), got %v\n", persons[0].Position)
+	}
+}
+
+func TestTrainAndEvaluate(t *testing.T) {
+	var b bytes.Buffer
+	w := recording.NewWriter(&b)
+	for k := 0; k < 10; k++ {
+		y := -400 + float64(k)*80
+		w.Write(scene([]mgl64.Vec2{{1200 + float64(k)*50, y}, {1200 + float64(k)*50, y + 300}}))
+	}
+	data := b.Bytes()
+
+	samples, err := Samples(bytes.NewReader(data), DefaultConfig().Segment, 150)
+	if err != nil {
+		t.Fatalf("Samples failed: %v\n", err)
+	}
+	cfg := DefaultConfig()
+	if cfg.Thresholds, err = Train(samples, 0.0); err != nil {
+		t.Fatalf("Train failed: %v\n", err)
+	}
+	e, err := Evaluate(bytes.NewReader(data), NewDetector(cfg), 150)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v\n", err)
+	}
+	if e.Scans != 10 || e.Recall() < 0.9 || e.Precision() < 0.9 {
+		t.Fatalf("Expected a good detector, got %+v precision %v recall %v\n", e, e.Precision(), e.Recall())
+	}
+}
+
+func TestTrainQuantile(t *testing.T) {
+	for _, q := range []float64{-0.1, 0.6, math.NaN()} {
+		if _, err := Train(nil, q); err == nil {
+			t.Fatalf("Expected quantile %v to be rejected\n", q)
+		}
+	}
+}
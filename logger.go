@@ -0,0 +1,33 @@
+package gohokuyolidar
+
+// Logger receives levelled structured events from the driver: every
+// command sent, response status, decode error and state transition. The
+// arguments are alternating keys and values, so a *slog.Logger can be
+// used directly. The driver is silent when no logger is set.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// SetLogger installs the logger of the driver. A nil l silences it.
+func (h *HokuyoLidar) SetLogger(l Logger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.log = l
+}
+
+func (h *HokuyoLidar) logger() Logger {
+	if h.log == nil {
+		return nopLogger{}
+	}
+	return h.log
+}
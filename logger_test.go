@@ -0,0 +1,40 @@
+package gohokuyolidar
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	events []string
+}
+
+func (r *recordingLogger) record(level, msg string, args []interface{}) {
+	r.events = append(r.events, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, args...)...)))
+}
+
+func (r *recordingLogger) Debug(msg string, args ...interface{}) { r.record("DEBUG", msg, args) }
+func (r *recordingLogger) Info(msg string, args ...interface{})  { r.record("INFO", msg, args) }
+func (r *recordingLogger) Warn(msg string, args ...interface{})  { r.record("WARN", msg, args) }
+func (r *recordingLogger) Error(msg string, args ...interface{}) { r.record("ERROR", msg, args) }
+
+func TestLogger(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	// silent by default
+	h.checkStatus("MD", "00")
+
+	l := &recordingLogger{}
+	h.SetLogger(l)
+	h.checkStatus("MD", "00")
+	h.checkStatus("GD", "04")
+	h.verify([]byte("00"), 'Q')
+	want := []string{
+		"DEBUG response command MD status 00",
+		"WARN response command GD status 04",
+		"WARN checksum mismatch expected P received Q",
+	}
+	if strings.Join(l.events, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Expected events\n%v\ngot\n%v\n", strings.Join(want, "\n"), strings.Join(l.events, "\n"))
+	}
+}
@@ -0,0 +1,68 @@
+package mcl
+
+import (
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/occgrid"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// likelihoodField holds the distance of every cell of the map to the
+// nearest occupied cell.
+type likelihoodField struct {
+	grid     *occgrid.Grid
+	distance []float64 // millimeters
+}
+
+// newLikelihoodField computes the distance map with a two pass chamfer
+// distance transform.
+func newLikelihoodField(grid *occgrid.Grid, maxDistance float64) *likelihoodField {
+	w, h := grid.Width, grid.Height
+	dist := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if grid.Occupied(x, y) {
+				dist[y*w+x] = 0
+			} else {
+				dist[y*w+x] = maxDistance
+			}
+		}
+	}
+	r := grid.Resolution
+	diag := r * math.Sqrt2
+	relax := func(x, y, nx, ny int, cost float64) {
+		if nx < 0 || ny < 0 || nx >= w || ny >= h {
+			return
+		}
+		if d := dist[ny*w+nx] + cost; d < dist[y*w+x] {
+			dist[y*w+x] = d
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			relax(x, y, x-1, y, r)
+			relax(x, y, x, y-1, r)
+			relax(x, y, x-1, y-1, diag)
+			relax(x, y, x+1, y-1, diag)
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			relax(x, y, x+1, y, r)
+			relax(x, y, x, y+1, r)
+			relax(x, y, x+1, y+1, diag)
+			relax(x, y, x-1, y+1, diag)
+		}
+	}
+	return &likelihoodField{grid: grid, distance: dist}
+}
+
+// at returns the distance to the nearest obstacle, or ok false outside of
+// the map.
+func (f *likelihoodField) at(p mgl64.Vec2) (float64, bool) {
+	x, y := f.grid.Cell(p)
+	if !f.grid.Contains(x, y) {
+		return 0, false
+	}
+	return f.distance[y*f.grid.Width+x], true
+}
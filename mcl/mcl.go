@@ -0,0 +1,300 @@
+// Package mcl localizes the sensor in a known occupancy grid map with Monte
+// Carlo localization.
+//
+// The filter uses the odometry motion model and a likelihood field sensor
+// model. It follows augmented MCL: short and long term averages of the
+// measurement likelihood decide how many random particles are injected,
+// which recovers from a wrong or kidnapped pose.
+package mcl
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/occgrid"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Config holds the parameters of the localizer.
+type Config struct {
+	// Particles is the number of particles of the filter.
+	Particles int
+	// Alpha1 to Alpha4 scale the odometry noise variances: rotation from
+	// rotation, rotation from translation, translation from translation and
+	// translation from rotation, with translations in millimeters.
+	Alpha1, Alpha2, Alpha3, Alpha4 float64
+	// SigmaHit is the standard deviation of a measurement in millimeters.
+	SigmaHit float64
+	// ZHit and ZRand mix the gaussian and uniform parts of the beam model.
+	ZHit, ZRand float64
+	// MinRange and MaxRange bound valid distances in millimeters.
+	MinRange, MaxRange int
+	// Beams is the number of beams of a scan used to weigh the particles.
+	Beams int
+	// AlphaSlow and AlphaFast are the decay rates of the long and short
+	// term likelihood averages.
+	AlphaSlow, AlphaFast float64
+	// Seed makes the filter repeatable.
+	Seed int64
+}
+
+// DefaultConfig returns parameters suited for the URG-04LX.
+func DefaultConfig() Config {
+	return Config{
+		Particles: 500,
+		Alpha1:    0.05,
+		Alpha2:    1e-7,
+		Alpha3:    0.05,
+		Alpha4:    0.05,
+		SigmaHit:  50.0,
+		ZHit:      0.9,
+		ZRand:     0.1,
+		MinRange:  20,
+		MaxRange:  5600,
+		Beams:     60,
+		AlphaSlow: 0.001,
+		AlphaFast: 0.1,
+		Seed:      1,
+	}
+}
+
+// Particle is a pose hypothesis and its weight.
+type Particle struct {
+	Pose   geom.Pose
+	Weight float64
+}
+
+// Localizer is a particle filter localizing a sensor in a map.
+type Localizer struct {
+	config    Config
+	grid      *occgrid.Grid
+	field     *likelihoodField
+	free      [][2]int
+	particles []Particle
+	rng       *rand.Rand
+	wSlow     float64
+	wFast     float64
+}
+
+// NewLocalizer creates a localizer for a map, as returned by
+// occgrid.LoadMap. It starts with a global initialization.
+func NewLocalizer(grid *occgrid.Grid, config Config) (*Localizer, error) {
+	l := &Localizer{
+		config: config,
+		grid:   grid,
+		field:  newLikelihoodField(grid, 3.0*config.SigmaHit+grid.Resolution),
+		rng:    rand.New(rand.NewSource(config.Seed)),
+	}
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			if grid.Free(x, y) {
+				l.free = append(l.free, [2]int{x, y})
+			}
+		}
+	}
+	if len(l.free) == 0 {
+		return nil, errors.New("Map has no free space to localize in")
+	}
+	l.InitializeGlobal()
+	return l, nil
+}
+
+// Initialize spreads the particles around pose following the covariance
+// of (X, Y, Theta). Only the diagonal of the covariance is used.
+func (l *Localizer) Initialize(pose geom.Pose, covariance mgl64.Mat3) {
+	sx := math.Sqrt(covariance.At(0, 0))
+	sy := math.Sqrt(covariance.At(1, 1))
+	st := math.Sqrt(covariance.At(2, 2))
+	l.particles = make([]Particle, l.config.Particles)
+	for i := range l.particles {
+		l.particles[i] = Particle{
+			Pose: geom.Pose{
+				X:     pose.X + l.rng.NormFloat64()*sx,
+				Y:     pose.Y + l.rng.NormFloat64()*sy,
+				Theta: geom.NormalizeAngle(pose.Theta + l.rng.NormFloat64()*st),
+			},
+			Weight: 1.0 / float64(len(l.particles)),
+		}
+	}
+	l.wSlow, l.wFast = 0, 0
+}
+
+// InitializeGlobal spreads the particles uniformly over the free space of
+// the map.
+func (l *Localizer) InitializeGlobal() {
+	l.particles = make([]Particle, l.config.Particles)
+	for i := range l.particles {
+		l.particles[i] = Particle{Pose: l.randomPose(), Weight: 1.0 / float64(len(l.particles))}
+	}
+	l.wSlow, l.wFast = 0, 0
+}
+
+func (l *Localizer) randomPose() geom.Pose {
+	c := l.free[l.rng.Intn(len(l.free))]
+	p := l.grid.Center(c[0], c[1])
+	half := l.grid.Resolution / 2.0
+	return geom.Pose{
+		X:     p[0] + (l.rng.Float64()*2-1)*half,
+		Y:     p[1] + (l.rng.Float64()*2-1)*half,
+		Theta: (l.rng.Float64()*2 - 1) * math.Pi,
+	}
+}
+
+// Predict moves the particles by the odometry motion since the last call,
+// expressed in the frame of the previous pose, e.g. from Odometry or the
+// wheel encoders.
+func (l *Localizer) Predict(motion geom.Pose) {
+	trans := math.Hypot(motion.X, motion.Y)
+	rot1 := 0.0
+	if trans > 1e-6 {
+		rot1 = math.Atan2(motion.Y, motion.X)
+	}
+	rot2 := geom.NormalizeAngle(motion.Theta - rot1)
+	// treat backwards motion as a small first rotation
+	if math.Abs(rot1) > math.Pi/2 {
+		rot1 = geom.NormalizeAngle(rot1 - math.Pi)
+		rot2 = geom.NormalizeAngle(motion.Theta - rot1)
+		trans = -trans
+	}
+
+	c := l.config
+	sRot1 := math.Sqrt(c.Alpha1*rot1*rot1 + c.Alpha2*trans*trans)
+	sTrans := math.Sqrt(c.Alpha3*trans*trans + c.Alpha4*(rot1*rot1+rot2*rot2))
+	sRot2 := math.Sqrt(c.Alpha1*rot2*rot2 + c.Alpha2*trans*trans)
+	for i := range l.particles {
+		r1 := rot1 + l.rng.NormFloat64()*sRot1
+		t := trans + l.rng.NormFloat64()*sTrans
+		r2 := rot2 + l.rng.NormFloat64()*sRot2
+		p := l.particles[i].Pose
+		p.X += t * math.Cos(p.Theta+r1)
+		p.Y += t * math.Sin(p.Theta+r1)
+		p.Theta = geom.NormalizeAngle(p.Theta + r1 + r2)
+		l.particles[i].Pose = p
+	}
+}
+
+// Update weighs the particles with a scan and resamples them. distances are
+// the raw values returned by GetDistance, startAngle is the bearing of the
+// first step and step the angle between steps, both in radians.
+func (l *Localizer) Update(distances []int, startAngle, step float64) {
+	beams := []mgl64.Vec2{}
+	stride := len(distances) / l.config.Beams
+	if stride < 1 {
+		stride = 1
+	}
+	for i := 0; i < len(distances); i += stride {
+		d := distances[i]
+		if d < l.config.MinRange || d >= l.config.MaxRange {
+			continue
+		}
+		theta := startAngle + float64(i)*step
+		beams = append(beams, mgl64.Vec2{float64(d) * math.Cos(theta), float64(d) * math.Sin(theta)})
+	}
+	if len(beams) == 0 {
+		return
+	}
+
+	logs := make([]float64, len(l.particles))
+	best := math.Inf(-1)
+	for i, p := range l.particles {
+		logs[i] = l.logLikelihood(p.Pose, beams)
+		best = math.Max(best, logs[i])
+	}
+	sum, avg := 0.0, 0.0
+	for i := range l.particles {
+		l.particles[i].Weight *= math.Exp(logs[i] - best)
+		sum += l.particles[i].Weight
+		// the geometric mean per beam keeps the average comparable
+		// between scans with a different number of beams
+		avg += math.Exp(logs[i] / float64(len(beams)))
+	}
+	avg /= float64(len(l.particles))
+	for i := range l.particles {
+		if sum > 0 {
+			l.particles[i].Weight /= sum
+		} else {
+			l.particles[i].Weight = 1.0 / float64(len(l.particles))
+		}
+	}
+
+	if l.wSlow == 0 {
+		l.wSlow, l.wFast = avg, avg
+	} else {
+		l.wSlow += l.config.AlphaSlow * (avg - l.wSlow)
+		l.wFast += l.config.AlphaFast * (avg - l.wFast)
+	}
+	l.resample()
+}
+
+func (l *Localizer) logLikelihood(pose geom.Pose, beams []mgl64.Vec2) float64 {
+	c := l.config
+	uniform := c.ZRand / float64(c.MaxRange)
+	norm := 1.0 / (math.Sqrt(2*math.Pi) * c.SigmaHit)
+	sum := 0.0
+	for _, b := range beams {
+		p := uniform
+		if d, ok := l.field.at(pose.Transform(b)); ok {
+			p += c.ZHit * norm * math.Exp(-d*d/(2.0*c.SigmaHit*c.SigmaHit))
+		}
+		sum += math.Log(p)
+	}
+	return sum
+}
+
+// resample draws a new particle set with the low variance sampler and
+// replaces a fraction of it with random poses when the short term
+// likelihood drops below the long term one.
+func (l *Localizer) resample() {
+	n := len(l.particles)
+	inject := 0.0
+	if l.wSlow > 0 {
+		inject = math.Max(0, 1.0-l.wFast/l.wSlow)
+	}
+	next := make([]Particle, 0, n)
+	r := l.rng.Float64() / float64(n)
+	c := l.particles[0].Weight
+	i := 0
+	for m := 0; m < n; m++ {
+		if l.rng.Float64() < inject {
+			next = append(next, Particle{Pose: l.randomPose()})
+			continue
+		}
+		u := r + float64(m)/float64(n)
+		for u > c && i < n-1 {
+			i++
+			c += l.particles[i].Weight
+		}
+		next = append(next, Particle{Pose: l.particles[i].Pose})
+	}
+	for k := range next {
+		next[k].Weight = 1.0 / float64(n)
+	}
+	l.particles = next
+}
+
+// Estimate returns the weighted mean pose of the particles and its
+// covariance of (X, Y, Theta).
+func (l *Localizer) Estimate() (geom.Pose, mgl64.Mat3) {
+	var x, y, s, c, total float64
+	for _, p := range l.particles {
+		x += p.Weight * p.Pose.X
+		y += p.Weight * p.Pose.Y
+		s += p.Weight * math.Sin(p.Pose.Theta)
+		c += p.Weight * math.Cos(p.Pose.Theta)
+		total += p.Weight
+	}
+	mean := geom.Pose{X: x / total, Y: y / total, Theta: math.Atan2(s, c)}
+	var cov mgl64.Mat3
+	for _, p := range l.particles {
+		d := mgl64.Vec3{p.Pose.X - mean.X, p.Pose.Y - mean.Y, geom.NormalizeAngle(p.Pose.Theta - mean.Theta)}
+		cov = cov.Add(d.OuterProd3(d).Mul(p.Weight / total))
+	}
+	return mean, cov
+}
+
+// Particles returns the current particle set.
+func (l *Localizer) Particles() []Particle {
+	return l.particles
+}
@@ -0,0 +1,142 @@
+package mcl
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/occgrid"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+const (
+	startAngle = -2.09
+	step       = 2 * math.Pi / 1024
+)
+
+// wall returns the distance to the closest wall of an L shaped room.
+func wall(pose geom.Pose, theta float64) int {
+	dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+	r := math.Inf(1)
+	hit := func(t float64, p mgl64.Vec2, inside bool) {
+		if t > 0 && inside {
+			r = math.Min(r, t)
+		}
+	}
+	for _, x := range []float64{-3000, 3000, 0} {
+		if dir[0] == 0 {
+			break
+		}
+		t := (x - pose.X) / dir[0]
+		p := pose.Vec().Add(dir.Mul(t))
+		hit(t, p, x != 0 || p[1] > 1000)
+	}
+	for _, y := range []float64{-2000, 2000, 1000} {
+		if dir[1] == 0 {
+			break
+		}
+		t := (y - pose.Y) / dir[1]
+		p := pose.Vec().Add(dir.Mul(t))
+		hit(t, p, y != 1000 || p[0] > 0)
+	}
+	return int(r)
+}
+
+func scan(pose geom.Pose) []int {
+	d := make([]int, 682)
+	for i := range d {
+		d[i] = wall(pose, pose.Theta+startAngle+float64(i)*step)
+	}
+	return d
+}
+
+func testMap() *occgrid.Grid {
+	cfg := occgrid.DefaultConfig()
+	cfg.Origin = mgl64.Vec2{-4000, -3000}
+	cfg.Width, cfg.Height = 160, 120
+	g := occgrid.NewGrid(cfg)
+	for _, p := range []geom.Pose{{X: -2000, Y: -1000}, {X: 2000, Y: -1000}, {X: -2000, Y: 1500}, {X: 2000, Y: 0}} {
+		for k := 0; k < 8; k++ {
+			p.Theta = float64(k) * math.Pi / 4
+			g.Integrate(p, scan(p), startAngle, step)
+		}
+	}
+	return g
+}
+
+func TestTracking(t *testing.T) {
+	l, err := NewLocalizer(testMap(), DefaultConfig())
+	if err != nil {
+		t.Fatalf("NewLocalizer failed: %v\n", err)
+	}
+	truth := geom.Pose{X: -1000, Y: -500, Theta: 0.3}
+	l.Initialize(geom.Pose{X: -800, Y: -600, Theta: 0.2}, mgl64.Diag3(mgl64.Vec3{200 * 200, 200 * 200, 0.1 * 0.1}))
+	motion := geom.Pose{X: 50, Theta: 0.02}
+	for i := 0; i < 20; i++ {
+		truth = truth.Compose(motion)
+		l.Predict(motion)
+		l.Update(scan(truth), startAngle, step)
+	}
+	est, cov := l.Estimate()
+	if math.Hypot(est.X-truth.X, est.Y-truth.Y) > 100 || math.Abs(geom.NormalizeAngle(est.Theta-truth.Theta)) > 0.05 {
+		t.Fatalf("Expected %v, got %v\n", truth, est)
+	}
+	if cov.At(0, 0) <= 0 || cov.At(0, 0) > 200*200 {
+		t.Fatalf("Expected a small positive covariance, got %v\n", cov)
+	}
+}
+
+func TestGlobalLocalization(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Particles = 4000
+	// a smoother sensor model keeps wrong hypotheses from winning early
+	cfg.SigmaHit = 100
+	l, err := NewLocalizer(testMap(), cfg)
+	if err != nil {
+		t.Fatalf("NewLocalizer failed: %v\n", err)
+	}
+	truth := geom.Pose{X: -1000, Y: -1000}
+	motion := geom.Pose{X: 40, Theta: 0.05}
+	for i := 0; i < 60; i++ {
+		truth = truth.Compose(motion)
+		l.Predict(motion)
+		l.Update(scan(truth), startAngle, step)
+	}
+	est, _ := l.Estimate()
+	if math.Hypot(est.X-truth.X, est.Y-truth.Y) > 150 || math.Abs(geom.NormalizeAngle(est.Theta-truth.Theta)) > 0.05 {
+		t.Fatalf("Expected the global localization to find %v, got %v\n", truth, est)
+	}
+}
+
+func TestKidnappedRobot(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Particles = 4000
+	l, err := NewLocalizer(testMap(), cfg)
+	if err != nil {
+		t.Fatalf("NewLocalizer failed: %v\n", err)
+	}
+	truth := geom.Pose{X: -1000, Y: -500, Theta: 0.3}
+	l.Initialize(truth, mgl64.Diag3(mgl64.Vec3{100 * 100, 100 * 100, 0.05 * 0.05}))
+	motion := geom.Pose{X: 40, Theta: 0.02}
+	for i := 0; i < 10; i++ {
+		truth = truth.Compose(motion)
+		l.Predict(motion)
+		l.Update(scan(truth), startAngle, step)
+	}
+
+	// carried to the other side of the room without odometry
+	truth = geom.Pose{X: 1500, Y: -800, Theta: 2.5}
+	motion = geom.Pose{X: 10, Theta: 0.05}
+	recovered := false
+	for i := 0; i < 60 && !recovered; i++ {
+		truth = truth.Compose(motion)
+		l.Predict(motion)
+		l.Update(scan(truth), startAngle, step)
+		est, _ := l.Estimate()
+		recovered = math.Hypot(est.X-truth.X, est.Y-truth.Y) < 100 && math.Abs(geom.NormalizeAngle(est.Theta-truth.Theta)) < 0.05
+	}
+	if !recovered {
+		est, _ := l.Estimate()
+		t.Fatalf("Expected the random particles to recover %v, got %v\n", truth, est)
+	}
+}
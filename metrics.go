@@ -0,0 +1,268 @@
+package gohokuyolidar
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from the driver. Implementations must be
+// safe for concurrent use. Use SetMetrics to install one, for example a
+// *DriverMetrics or an adapter to another metrics library.
+type Metrics interface {
+	// ScanRead is called for every scan read by GetDistance or
+	// GetDistanceAndIntensity.
+	ScanRead(stats ScanStats)
+	// ChecksumError is called for every block with a wrong checksum.
+	ChecksumError()
+	// Timeout is called when the sensor sent fewer bytes than expected.
+	Timeout()
+	// Reconnect is called when a lidar connects again after a disconnect.
+	Reconnect()
+	// Status is called with the status code of every command response.
+	Status(command, code string)
+}
+
+// ScanStats describes a single scan.
+type ScanStats struct {
+	Latency   time.Duration // time spent reading the scan
+	Timestamp int           // sensor time in milliseconds
+	Period    time.Duration // expected time between two scans, 0 for GD/GS
+	Points    int
+	Invalid   int // points with an error code instead of a distance
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ScanRead(ScanStats)    {}
+func (nopMetrics) ChecksumError()        {}
+func (nopMetrics) Timeout()              {}
+func (nopMetrics) Reconnect()            {}
+func (nopMetrics) Status(string, string) {}
+
+// SetMetrics installs the metrics receiving the driver measurements. A nil
+// m disables the measurements.
+func (h *HokuyoLidar) SetMetrics(m Metrics) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.metrics = m
+}
+
+func (h *HokuyoLidar) observer() Metrics {
+	if h.metrics == nil {
+		return nopMetrics{}
+	}
+	return h.metrics
+}
+
+// scanPeriod is the expected time between two scans of the current
+// MD/MS request.
+func (h *HokuyoLidar) scanPeriod() time.Duration {
+	return time.Minute / time.Duration(h.spec.ScanSpeed) * time.Duration(h.scan.ScanInterval+1)
+}
+
+// checksum computes the SCIP checksum of a block: the lower six bits of
+// the sum of its bytes plus 0x30.
+func checksum(block []byte) byte {
+	var sum byte
+	for _, b := range block {
+		sum += b
+	}
+	return sum&0x3f + 0x30
+}
+
+// verify reports and returns a checksum error when block does not match
+// sum.
+func (h *HokuyoLidar) verify(block []byte, sum byte) error {
+	if expected := checksum(block); expected != sum {
+		h.observer().ChecksumError()
+		h.logger().Warn("checksum mismatch", "expected", string(expected), "received", string(sum))
+		return fmt.Errorf("Block %q has checksum %c instead of %c: %w", block, sum, expected, ErrChecksum)
+	}
+	return nil
+}
+
+// histogram is a cumulative histogram in the Prometheus sense.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (hi *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(hi.bounds, v)
+	hi.counts[i]++
+	hi.sum += v
+	hi.count++
+}
+
+func (hi *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, b := range hi.bounds {
+		cumulative += hi.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, hi.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, hi.sum, name, hi.count)
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+}
+
+type statusKey struct {
+	command, code string
+}
+
+// DriverMetrics collects the driver measurements and exposes them in the
+// Prometheus text format. The scan rate is the rate of
+// hokuyo_scans_total.
+type DriverMetrics struct {
+	mutex sync.Mutex
+
+	scans          uint64
+	points         uint64
+	invalidPoints  uint64
+	checksumErrors uint64
+	timeouts       uint64
+	reconnects     uint64
+	status         map[statusKey]uint64
+
+	latency *histogram // seconds
+	jitter  *histogram // seconds
+	invalid *histogram // fraction per scan
+
+	lastTimestamp int
+	hasTimestamp  bool
+}
+
+// NewDriverMetrics creates empty metrics.
+func NewDriverMetrics() *DriverMetrics {
+	return &DriverMetrics{
+		status:  map[statusKey]uint64{},
+		latency: newHistogram(0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1),
+		jitter:  newHistogram(0.001, 0.002, 0.005, 0.01, 0.025, 0.05, 0.1),
+		invalid: newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 1),
+	}
+}
+
+// ScanRead implements Metrics. The jitter is the deviation of the time
+// between two consecutive sensor timestamps of a stream from the expected
+// period, single scans are not periodic.
+func (m *DriverMetrics) ScanRead(stats ScanStats) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.scans++
+	m.points += uint64(stats.Points)
+	m.invalidPoints += uint64(stats.Invalid)
+	m.latency.observe(stats.Latency.Seconds())
+	if stats.Points > 0 {
+		m.invalid.observe(float64(stats.Invalid) / float64(stats.Points))
+	}
+	if stats.Period <= 0 {
+		m.hasTimestamp = false
+		return
+	}
+	if m.hasTimestamp {
+		// the sensor clock is 24 bits wide
+		elapsed := (stats.Timestamp - m.lastTimestamp) & (1<<24 - 1)
+		deviation := time.Duration(elapsed)*time.Millisecond - stats.Period
+		m.jitter.observe(math.Abs(deviation.Seconds()))
+	}
+	m.lastTimestamp = stats.Timestamp
+	m.hasTimestamp = true
+}
+
+// ChecksumError implements Metrics.
+func (m *DriverMetrics) ChecksumError() {
+	m.mutex.Lock()
+	m.checksumErrors++
+	m.mutex.Unlock()
+}
+
+// Timeout implements Metrics.
+func (m *DriverMetrics) Timeout() {
+	m.mutex.Lock()
+	m.timeouts++
+	m.mutex.Unlock()
+}
+
+// Reconnect implements Metrics.
+func (m *DriverMetrics) Reconnect() {
+	m.mutex.Lock()
+	m.reconnects++
+	// the sensor clock restarts, don't measure jitter across it
+	m.hasTimestamp = false
+	m.mutex.Unlock()
+}
+
+// Status implements Metrics.
+func (m *DriverMetrics) Status(command, code string) {
+	m.mutex.Lock()
+	m.status[statusKey{command, code}]++
+	m.mutex.Unlock()
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *DriverMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cw := &countingWriter{w: w}
+	writeCounter(cw, "hokuyo_scans_total", "Scans read from the sensor.", m.scans)
+	writeCounter(cw, "hokuyo_points_total", "Points read from the sensor.", m.points)
+	writeCounter(cw, "hokuyo_invalid_points_total", "Points with an error code instead of a distance.", m.invalidPoints)
+	writeCounter(cw, "hokuyo_checksum_errors_total", "Blocks with a wrong checksum.", m.checksumErrors)
+	writeCounter(cw, "hokuyo_timeouts_total", "Reads returning fewer bytes than expected.", m.timeouts)
+	writeCounter(cw, "hokuyo_reconnects_total", "Connections after a disconnect.", m.reconnects)
+	m.latency.write(cw, "hokuyo_read_latency_seconds", "Time spent reading a scan.")
+	m.jitter.write(cw, "hokuyo_scan_jitter_seconds", "Deviation of the time between scans from the expected period.")
+	m.invalid.write(cw, "hokuyo_invalid_fraction", "Fraction of invalid points per scan.")
+
+	keys := make([]statusKey, 0, len(m.status))
+	for k := range m.status {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].command != keys[j].command {
+			return keys[i].command < keys[j].command
+		}
+		return keys[i].code < keys[j].code
+	})
+	fmt.Fprintf(cw, "# HELP hokuyo_status_total Status codes reported by the sensor.\n# TYPE hokuyo_status_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(cw, "hokuyo_status_total{command=%q,code=%q} %d\n", k.command, k.code, m.status[k])
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics, e.g. on /metrics.
+func (m *DriverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,56 @@
+package gohokuyolidar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksum(t *testing.T) {
+	// status "00" of the SCIP 2.0 specification has checksum 'P'
+	if sum := checksum([]byte("00")); sum != 'P' {
+		t.Fatalf("Expected checksum P, got %c\n", sum)
+	}
+}
+
+func TestDriverMetrics(t *testing.T) {
+	m := NewDriverMetrics()
+	h := NewHokuyoLidar("", 0)
+	h.SetMetrics(m)
+
+	h.requestTag = mTag
+	h.observeScan(time.Now(), 1000, []int{1000, 0, 2000, 1})
+	h.observeScan(time.Now(), 1103, []int{1000, 1500, 2000, 2500})
+	// single scans taken on demand don't count as jitter
+	h.requestTag = gTag
+	h.observeScan(time.Now(), 5000, []int{1000})
+	h.observeScan(time.Now(), 9000, []int{1000})
+	h.requestTag = mTag
+	h.observeScan(time.Now(), 12000, []int{1000})
+	h.verify([]byte("00"), 'Q')
+	h.checkStatus("MD", "00")
+	h.checkStatus("MD", "04")
+
+	var b bytes.Buffer
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"hokuyo_scans_total 5\n",
+		"hokuyo_invalid_points_total 2\n",
+		"hokuyo_checksum_errors_total 1\n",
+		// 103 ms apart instead of 100 ms
+		"hokuyo_scan_jitter_seconds_bucket{le=\"0.002\"} 0\n",
+		"hokuyo_scan_jitter_seconds_bucket{le=\"0.005\"} 1\n",
+		"hokuyo_scan_jitter_seconds_count 1\n",
+		"hokuyo_invalid_fraction_bucket{le=\"0.5\"} 5\n",
+		"hokuyo_invalid_fraction_bucket{le=\"0.25\"} 4\n",
+		"hokuyo_status_total{command=\"MD\",code=\"04\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("Expected %q in\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package occgrid
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Thresholds used when exporting, matching the map_server defaults.
+const (
+	OccupiedThreshold = 0.65
+	FreeThreshold     = 0.196
+)
+
+const (
+	occupiedPixel uint8 = 0
+	freePixel     uint8 = 254
+	unknownPixel  uint8 = 205
+)
+
+// Image renders the grid with occupied cells black, free cells white and
+// unknown cells grey. The top row of the image is the highest y.
+func (g *Grid) Image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, g.Width, g.Height))
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			img.SetGray(x, g.Height-1-y, color.Gray{Y: g.pixel(x, y)})
+		}
+	}
+	return img
+}
+
+func (g *Grid) pixel(x, y int) uint8 {
+	p := g.Probability(x, y)
+	if p > OccupiedThreshold {
+		return occupiedPixel
+	} else if p < FreeThreshold {
+		return freePixel
+	}
+	return unknownPixel
+}
+
+// WritePGM writes the grid as a binary PGM image.
+func (g *Grid) WritePGM(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n# CREATOR: gohokuyolidar %.3f m/pix\n%d %d\n255\n", g.Resolution/1000.0, g.Width, g.Height)
+	if _, err := bw.Write(g.Image().Pix); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// WriteYAML writes the map_server metadata for a map image.
+func (g *Grid) WriteYAML(w io.Writer, image string) error {
+	_, err := fmt.Fprintf(w, "image: %s\nresolution: %f\norigin: [%f, %f, 0.000000]\nnegate: 0\noccupied_thresh: %g\nfree_thresh: %g\n",
+		image, g.Resolution/1000.0, g.Origin[0]/1000.0, g.Origin[1]/1000.0, OccupiedThreshold, FreeThreshold)
+	return err
+}
+
+// WritePNG writes the grid as a PNG image.
+func (g *Grid) WritePNG(w io.Writer) error {
+	return png.Encode(w, g.Image())
+}
+
+// SaveMap writes base.pgm and base.yaml in the map_server format.
+func (g *Grid) SaveMap(base string) error {
+	pgm, err := os.Create(base + ".pgm")
+	if err != nil {
+		return err
+	}
+	defer pgm.Close()
+	if err := g.WritePGM(pgm); err != nil {
+		return fmt.Errorf("Failed to write map image: %v", err)
+	}
+	yml, err := os.Create(base + ".yaml")
+	if err != nil {
+		return err
+	}
+	defer yml.Close()
+	if err := g.WriteYAML(yml, filepath.Base(base)+".pgm"); err != nil {
+		return fmt.Errorf("Failed to write map metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package occgrid builds occupancy grid maps from posed scans.
+package occgrid
+
+import (
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Config holds the parameters of an occupancy grid.
+type Config struct {
+	Resolution float64    // size of a cell in millimeters
+	Origin     mgl64.Vec2 // world position of the corner of cell (0, 0)
+	Width      int        // number of cells along x
+	Height     int        // number of cells along y
+
+	LogOddsHit  float64 // added to a cell containing a return
+	LogOddsMiss float64 // added to a cell a ray passed through
+	LogOddsMin  float64 // lower clamp of a cell
+	LogOddsMax  float64 // upper clamp of a cell
+
+	// MinRange and MaxRange bound valid distances in millimeters. Values
+	// below MinRange are error codes of the sensor.
+	MinRange int
+	MaxRange int
+	// NoReturnAsFree traces a free ray up to MaxRange for steps that report
+	// an error code, since most of them mean nothing reflected the laser.
+	NoReturnAsFree bool
+}
+
+// DefaultConfig returns a 20m by 20m map at 50mm resolution centred on the
+// origin, with the range limits of the URG-04LX.
+func DefaultConfig() Config {
+	return Config{
+		Resolution:     50.0,
+		Origin:         mgl64.Vec2{-10000, -10000},
+		Width:          400,
+		Height:         400,
+		LogOddsHit:     0.85,
+		LogOddsMiss:    -0.4,
+		LogOddsMin:     -5.0,
+		LogOddsMax:     5.0,
+		MinRange:       20,
+		MaxRange:       5600,
+		NoReturnAsFree: true,
+	}
+}
+
+// Grid is an occupancy grid storing the log-odds of each cell being occupied.
+type Grid struct {
+	Config
+	LogOdds []float64 // row major, row 0 is the lowest y
+}
+
+// NewGrid creates a grid where every cell is unknown.
+func NewGrid(config Config) *Grid {
+	return &Grid{Config: config, LogOdds: make([]float64, config.Width*config.Height)}
+}
+
+// Cell returns the indices of the cell containing p.
+func (g *Grid) Cell(p mgl64.Vec2) (int, int) {
+	return int(math.Floor((p[0] - g.Origin[0]) / g.Resolution)),
+		int(math.Floor((p[1] - g.Origin[1]) / g.Resolution))
+}
+
+// Center returns the world position of the center of a cell.
+func (g *Grid) Center(x, y int) mgl64.Vec2 {
+	return mgl64.Vec2{
+		g.Origin[0] + (float64(x)+0.5)*g.Resolution,
+		g.Origin[1] + (float64(y)+0.5)*g.Resolution,
+	}
+}
+
+// Contains reports whether the cell lies inside the grid.
+func (g *Grid) Contains(x, y int) bool {
+	return x >= 0 && y >= 0 && x < g.Width && y < g.Height
+}
+
+// Probability returns the occupancy probability of a cell, 0.5 if unknown
+// or outside of the grid.
+func (g *Grid) Probability(x, y int) float64 {
+	if !g.Contains(x, y) {
+		return 0.5
+	}
+	return 1.0 - 1.0/(1.0+math.Exp(g.LogOdds[y*g.Width+x]))
+}
+
+// Integrate adds a scan taken at pose to the map. distances are the raw
+// values returned by GetDistance, startAngle is the bearing of the first
+// step and step the angle between steps, both in radians.
+func (g *Grid) Integrate(pose geom.Pose, distances []int, startAngle, step float64) {
+	ox, oy := g.Cell(pose.Vec())
+	for i, d := range distances {
+		hit := true
+		if d < g.MinRange {
+			if !g.NoReturnAsFree {
+				continue
+			}
+			d = g.MaxRange
+			hit = false
+		} else if d >= g.MaxRange {
+			d = g.MaxRange
+			hit = false
+		}
+		theta := startAngle + float64(i)*step
+		end := pose.Transform(mgl64.Vec2{float64(d) * math.Cos(theta), float64(d) * math.Sin(theta)})
+		ex, ey := g.Cell(end)
+		g.trace(ox, oy, ex, ey, hit)
+	}
+}
+
+// IntegratePoints adds a scan given as cartesian points in the sensor frame.
+// Zero vectors are skipped as they carry no bearing.
+func (g *Grid) IntegratePoints(pose geom.Pose, points []mgl64.Vec2) {
+	ox, oy := g.Cell(pose.Vec())
+	for _, p := range points {
+		if p[0] == 0 && p[1] == 0 {
+			continue
+		}
+		ex, ey := g.Cell(pose.Transform(p))
+		g.trace(ox, oy, ex, ey, true)
+	}
+}
+
+// trace walks the cells from (x0, y0) to (x1, y1) with Bresenham's line
+// algorithm, marking them free and the last one occupied if hit is set.
+func (g *Grid) trace(x0, y0, x1, y1 int, hit bool) {
+	dx := abs(x1 - x0)
+	dy := -abs(y1 - y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	x, y := x0, y0
+	for x != x1 || y != y1 {
+		g.update(x, y, g.LogOddsMiss)
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
+		}
+	}
+	if hit {
+		g.update(x1, y1, g.LogOddsHit)
+	} else {
+		g.update(x1, y1, g.LogOddsMiss)
+	}
+}
+
+func (g *Grid) update(x, y int, delta float64) {
+	if !g.Contains(x, y) {
+		return
+	}
+	i := y*g.Width + x
+	g.LogOdds[i] = math.Max(g.LogOddsMin, math.Min(g.LogOddsMax, g.LogOdds[i]+delta))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+package occgrid
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestIntegrate(t *testing.T) {
+	g := NewGrid(DefaultConfig())
+	for i := 0; i < 5; i++ {
+		g.Integrate(geom.Pose{}, []int{2000}, 0, 0)
+	}
+	if p := g.Probability(g.Cell(mgl64.Vec2{2000, 0})); p < OccupiedThreshold {
+		t.Fatalf("Expected the return to be occupied, got %v\n", p)
+	}
+	if p := g.Probability(g.Cell(mgl64.Vec2{1000, 0})); p > FreeThreshold {
+		t.Fatalf("Expected the ray to be free, got %v\n", p)
+	}
+	if p := g.Probability(g.Cell(mgl64.Vec2{3000, 0})); p != 0.5 {
+		t.Fatalf("Expected cells behind the return to be unknown, got %v\n", p)
+	}
+}
+
+func TestIntegrateNoReturn(t *testing.T) {
+	g := NewGrid(DefaultConfig())
+	for i := 0; i < 5; i++ {
+		g.Integrate(geom.Pose{}, []int{1}, 0, 0)
+	}
+	if p := g.Probability(g.Cell(mgl64.Vec2{5000, 0})); p > FreeThreshold {
+		t.Fatalf("Expected an error code to clear the ray, got %v\n", p)
+	}
+	if p := g.Probability(g.Cell(mgl64.Vec2{5600, 0})); p > 0.5 {
+		t.Fatalf("Expected no obstacle at the end of an error ray, got %v\n", p)
+	}
+}
+
+func TestWritePGM(t *testing.T) {
+	g := NewGrid(DefaultConfig())
+	var b bytes.Buffer
+	if err := g.WritePGM(&b); err != nil {
+		t.Fatalf("WritePGM failed: %v\n", err)
+	}
+	if !strings.HasPrefix(b.String(), "P5\n") {
+		t.Fatalf("Expected a binary PGM header, got %q\n", b.String()[:10])
+	}
+	if b.Len() < g.Width*g.Height {
+		t.Fatalf("Expected at least %v bytes, got %v\n", g.Width*g.Height, b.Len())
+	}
+}
+
+func TestSaveAndLoadMap(t *testing.T) {
+	g := NewGrid(DefaultConfig())
+	for i := 0; i < 5; i++ {
+		g.Integrate(geom.Pose{}, []int{2000}, 0, 0)
+	}
+	base := filepath.Join(t.TempDir(), "map")
+	if err := g.SaveMap(base); err != nil {
+		t.Fatalf("SaveMap failed: %v\n", err)
+	}
+	loaded, err := LoadMap(base+".yaml", DefaultConfig())
+	if err != nil {
+		t.Fatalf("LoadMap failed: %v\n", err)
+	}
+	if loaded.Width != g.Width || loaded.Resolution != g.Resolution || loaded.Origin != g.Origin {
+		t.Fatalf("Expected the same geometry, got %v %v %v\n", loaded.Width, loaded.Resolution, loaded.Origin)
+	}
+	if !loaded.Occupied(loaded.Cell(mgl64.Vec2{2000, 0})) || !loaded.Free(loaded.Cell(mgl64.Vec2{1000, 0})) {
+		t.Fatalf("Expected the loaded map to match the saved one\n")
+	}
+}
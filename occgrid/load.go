@@ -0,0 +1,193 @@
+package occgrid
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// MapMetadata is the content of a map_server YAML file.
+type MapMetadata struct {
+	Image          string
+	Resolution     float64 // meters per pixel
+	Origin         [3]float64
+	Negate         bool
+	OccupiedThresh float64
+	FreeThresh     float64
+}
+
+// ReadYAML parses the keys of a map_server YAML file this package needs.
+func ReadYAML(r io.Reader) (MapMetadata, error) {
+	meta := MapMetadata{OccupiedThresh: OccupiedThreshold, FreeThresh: FreeThreshold}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var err error
+		switch key {
+		case "image":
+			meta.Image = strings.Trim(value, "\"'")
+		case "resolution":
+			meta.Resolution, err = strconv.ParseFloat(value, 64)
+		case "negate":
+			meta.Negate = value == "1" || value == "true"
+		case "occupied_thresh":
+			meta.OccupiedThresh, err = strconv.ParseFloat(value, 64)
+		case "free_thresh":
+			meta.FreeThresh, err = strconv.ParseFloat(value, 64)
+		case "origin":
+			fields := strings.Split(strings.Trim(value, "[]"), ",")
+			if len(fields) != 3 {
+				return meta, fmt.Errorf("Invalid map origin: %v", value)
+			}
+			for i, f := range fields {
+				meta.Origin[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return meta, fmt.Errorf("Invalid value for %v: %v", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return meta, err
+	}
+	if meta.Image == "" || meta.Resolution <= 0 {
+		return meta, errors.New("Map metadata lacks image or resolution")
+	}
+	return meta, nil
+}
+
+// ReadPGM decodes a binary PGM image and returns its size and pixels, top
+// row first.
+func ReadPGM(r io.Reader) (int, int, []uint8, error) {
+	br := bufio.NewReader(r)
+	header := []int{}
+	var magic string
+	for len(header) < 3 {
+		token, err := pgmToken(br)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("Failed to read PGM header: %v", err)
+		}
+		if magic == "" {
+			magic = token
+			if magic != "P5" {
+				return 0, 0, nil, fmt.Errorf("Unsupported image format: %v", magic)
+			}
+			continue
+		}
+		v, err := strconv.Atoi(token)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("Invalid PGM header: %v", err)
+		}
+		header = append(header, v)
+	}
+	width, height, maxVal := header[0], header[1], header[2]
+	if maxVal > 255 {
+		return 0, 0, nil, errors.New("Only 8 bit PGM images are supported")
+	}
+	pix := make([]uint8, width*height)
+	if _, err := io.ReadFull(br, pix); err != nil {
+		return 0, 0, nil, fmt.Errorf("Failed to read PGM pixels: %v", err)
+	}
+	return width, height, pix, nil
+}
+
+// pgmToken reads the next whitespace separated header token, skipping
+// comments, and consumes the single whitespace following it.
+func pgmToken(r *bufio.Reader) (string, error) {
+	token := []byte{}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == '#' && len(token) == 0 {
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+		token = append(token, c)
+	}
+}
+
+// LoadMap reads a map in the map_server format. Occupied and free pixels
+// become cells at the clamped log-odds of config, unknown pixels stay
+// unknown. Resolution, origin and size are taken from the map.
+func LoadMap(yamlPath string, config Config) (*Grid, error) {
+	yml, err := os.Open(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer yml.Close()
+	meta, err := ReadYAML(yml)
+	if err != nil {
+		return nil, err
+	}
+	imagePath := meta.Image
+	if !filepath.IsAbs(imagePath) {
+		imagePath = filepath.Join(filepath.Dir(yamlPath), imagePath)
+	}
+	img, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+	width, height, pix, err := ReadPGM(img)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Resolution = meta.Resolution * 1000.0
+	config.Origin = mgl64.Vec2{meta.Origin[0] * 1000.0, meta.Origin[1] * 1000.0}
+	config.Width = width
+	config.Height = height
+	g := NewGrid(config)
+	for row := 0; row < height; row++ {
+		for x := 0; x < width; x++ {
+			v := float64(pix[row*width+x]) / 255.0
+			occ := 1.0 - v
+			if meta.Negate {
+				occ = v
+			}
+			i := (height-1-row)*width + x
+			if occ > meta.OccupiedThresh {
+				g.LogOdds[i] = config.LogOddsMax
+			} else if occ < meta.FreeThresh {
+				g.LogOdds[i] = config.LogOddsMin
+			}
+		}
+	}
+	return g, nil
+}
+
+// Occupied reports whether a cell is more likely occupied than the
+// export threshold.
+func (g *Grid) Occupied(x, y int) bool {
+	return g.Probability(x, y) > OccupiedThreshold
+}
+
+// Free reports whether a cell is more likely free than the export threshold.
+func (g *Grid) Free(x, y int) bool {
+	return g.Contains(x, y) && g.Probability(x, y) < FreeThreshold
+}
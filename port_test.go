@@ -0,0 +1,181 @@
+package gohokuyolidar
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// fakePort answers the commands written to it with a recorded response.
+type fakePort struct {
+	written bytes.Buffer
+	reply   *bytes.Reader
+}
+
+func (p *fakePort) Read(b []byte) (int, error)  { return p.reply.Read(b) }
+func (p *fakePort) Write(b []byte) (int, error) { return p.written.Write(b) }
+func (p *fakePort) Close() error                { return nil }
+
+// connectedLidar returns a lidar with the laser on, reading reply.
+func connectedLidar(reply []byte) (*HokuyoLidar, *fakePort) {
+	port := &fakePort{reply: bytes.NewReader(reply)}
+	h := NewHokuyoLidar("", 0)
+	h.serialPort = port
+	h.state = StateLaserOn
+	return h, port
+}
+
+func encode(v, size int) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(v&0x3f) + 0x30
+		v >>= 6
+	}
+	return b
+}
+
+func withSum(block []byte) []byte {
+	return append(block, checksum(block), lf)
+}
+
+// gdReply is the response of the sensor to a GD/GS command.
+func gdReply(command string, timestamp int, distances []int, size int) []byte {
+	reply := append([]byte(command), withSum([]byte("00"))...)
+	reply = append(reply, withSum(encode(timestamp, 4))...)
+	return append(reply, dataBlocks(distances, size)...)
+}
+
+func TestGDReply(t *testing.T) {
+	spec := DefaultSpec()
+	for _, c := range []ScanConfig{
+		DefaultScanConfig(spec),
+		{StartStep: 100, EndStep: 120, ClusterCount: 2, Encoding: TwoCharEncoding, Characters: "abc"},
+	} {
+		distances := []int{}
+		for i := 0; i < c.points(); i++ {
+			distances = append(distances, 20+i*37%4000)
+		}
+		size := 3
+		if c.Encoding == TwoCharEncoding {
+			size = 2
+		}
+		command := string([]byte{gTag, byte(c.Encoding)}) +
+			fmt.Sprintf("%04d%04d%02d", c.StartStep, c.EndStep, c.ClusterCount) + c.Characters + "\n"
+		h, port := connectedLidar(gdReply(command, 123456, distances, size))
+
+		if err := h.RequestScan(c); err != nil {
+			t.Fatalf("Expected the scan request to succeed, got %v\n", err)
+		}
+		if port.written.String() != command {
+			t.Fatalf("Expected the command %q, got %q\n", command, port.written.String())
+		}
+		read, timestamp, err := h.GetDistance()
+		if err != nil {
+			t.Fatalf("Expected to read the scan, got %v\n", err)
+		}
+		if timestamp != 123456 {
+			t.Fatalf("Expected timestamp 123456, got %v\n", timestamp)
+		}
+		if len(read) != len(distances) {
+			t.Fatalf("Expected %d distances, got %d\n", len(distances), len(read))
+		}
+		for i := range read {
+			if read[i] != distances[i] {
+				t.Fatalf("Expected distance %d at %d, got %d\n", distances[i], i, read[i])
+			}
+		}
+		if port.reply.Len() != 0 {
+			t.Fatalf("Expected the whole reply to be read, %d bytes left\n", port.reply.Len())
+		}
+		if h.State() != StateLaserOn {
+			t.Fatalf("Expected to stay in laser-on, got %v\n", h.State())
+		}
+	}
+}
+
+// mdReply is a scan of a running MD stream.
+func mdReply(command string, timestamp int, values []int) []byte {
+	reply := append([]byte(command), withSum([]byte("99"))...)
+	reply = append(reply, withSum(encode(timestamp, 4))...)
+	return append(reply, dataBlocks(values, 3)...)
+}
+
+// dataBlocks encodes values in blocks of 64 characters followed by the
+// empty line ending a scan.
+func dataBlocks(values []int, size int) []byte {
+	data := []byte{}
+	for _, v := range values {
+		data = append(data, encode(v, size)...)
+	}
+	blocks := []byte{}
+	for len(data) > 0 {
+		n := len(data)
+		if n > 64 {
+			n = 64
+		}
+		blocks = append(blocks, withSum(append([]byte{}, data[:n]...))...)
+		data = data[n:]
+	}
+	return append(blocks, lf)
+}
+
+func TestStreamedIntensityReply(t *testing.T) {
+	c := ScanConfig{StartStep: 384, EndStep: 399, ClusterCount: 1, Encoding: ThreeCharEncoding}
+	values := []int{}
+	for i := 0; i < c.points(); i++ {
+		values = append(values, 1000+i, 200+i)
+	}
+	scan := mdReply("MD0384039901000\n", 77, values)
+	h, port := connectedLidar(append(append([]byte{}, scan...), scan...))
+	h.state = StateStreaming
+	h.scan = c
+	h.requestTag = mTag
+	h.headSize = 21
+
+	for i := 0; i < 2; i++ {
+		distances, intensities, timestamp, err := h.GetDistanceAndIntensity()
+		if err != nil {
+			t.Fatalf("Expected to read scan %d, got %v\n", i, err)
+		}
+		if timestamp != 77 || len(distances) != c.points() || len(intensities) != c.points() {
+			t.Fatalf("Expected %d points at 77, got %d and %d at %v\n", c.points(), len(distances), len(intensities), timestamp)
+		}
+		for j := range distances {
+			if distances[j] != 1000+j || intensities[j] != 200+j {
+				t.Fatalf("Unexpected point %d: %d, %d\n", j, distances[j], intensities[j])
+			}
+		}
+	}
+	if port.reply.Len() != 0 {
+		t.Fatalf("Expected both scans to be read, %d bytes left\n", port.reply.Len())
+	}
+}
+
+func TestQuitStream(t *testing.T) {
+	c := ScanConfig{StartStep: 384, EndStep: 450, ClusterCount: 1, Encoding: ThreeCharEncoding}
+	values := []int{}
+	for i := 0; i < c.points(); i++ {
+		values = append(values, 0x864) // "0QT"
+	}
+	// a scan still in flight when QT arrives, full of "QT" characters
+	reply := mdReply("MD0384045001000\n", 5, values)
+	reply = append(reply, "QT\n00P\n\nBM\n00P\n\n"...)
+	h, port := connectedLidar(reply)
+	h.state = StateStreaming
+	h.scan = c
+	h.requestTag = mTag
+	h.headSize = 21
+
+	if err := h.QMCommand(""); err != nil {
+		t.Fatalf("Expected QT to stop the stream, got %v\n", err)
+	}
+	if h.State() != StateIdle {
+		t.Fatalf("Expected idle after QT, got %v\n", h.State())
+	}
+	if err := h.BMCommand(""); err != nil {
+		t.Fatalf("Expected the next command to read its own response, got %v\n", err)
+	}
+	if port.reply.Len() != 0 {
+		t.Fatalf("Expected every response to be read, %d bytes left\n", port.reply.Len())
+	}
+}
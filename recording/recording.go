@@ -0,0 +1,95 @@
+// Package recording stores scans as JSON lines so that they can be replayed
+// offline.
+package recording
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// MinRange is the smallest valid distance of the URG-04LX in millimeters.
+// Smaller values are error codes.
+const MinRange = 20
+
+// Scan is a single recorded scan.
+type Scan struct {
+	Timestamp   int     `json:"timestamp"`             // sensor time in milliseconds
+	StartAngle  float64 `json:"start_angle"`           // bearing of the first step in radians
+	Step        float64 `json:"step"`                  // angle between steps in radians
+	Distances   []int   `json:"distances"`             // millimeters or error codes
+	Intensities []int   `json:"intensities,omitempty"` // present for ME/GE scans
+
+	// Labels are ground truth positions in the sensor frame added by hand,
+	// e.g. the legs visible in the scan.
+	Labels []mgl64.Vec2 `json:"labels,omitempty"`
+}
+
+// Angle returns the bearing of step i in radians.
+func (s Scan) Angle(i int) float64 {
+	return s.StartAngle + float64(i)*s.Step
+}
+
+// Points converts the scan to cartesian points in the sensor frame. Steps
+// without a valid return become zero vectors like in DataToCartesian.
+func (s Scan) Points() []mgl64.Vec2 {
+	points := make([]mgl64.Vec2, len(s.Distances))
+	for i, d := range s.Distances {
+		if d < MinRange {
+			continue
+		}
+		theta := s.Angle(i)
+		points[i] = mgl64.Vec2{float64(d) * math.Cos(theta), float64(d) * math.Sin(theta)}
+	}
+	return points
+}
+
+// Writer appends scans to a recording.
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter creates a writer emitting one JSON object per line.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write appends a scan.
+func (w *Writer) Write(s Scan) error {
+	return w.enc.Encode(s)
+}
+
+// Reader reads scans back from a recording.
+type Reader struct {
+	dec *json.Decoder
+}
+
+// NewReader creates a reader for a JSON lines recording.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Read returns the next scan, or io.EOF at the end of the recording.
+func (r *Reader) Read() (Scan, error) {
+	var s Scan
+	err := r.dec.Decode(&s)
+	return s, err
+}
+
+// ReadAll reads every scan of a recording.
+func ReadAll(r io.Reader) ([]Scan, error) {
+	reader := NewReader(r)
+	scans := []Scan{}
+	for {
+		s, err := reader.Read()
+		if err == io.EOF {
+			return scans, nil
+		}
+		if err != nil {
+			return scans, err
+		}
+		scans = append(scans, s)
+	}
+}
@@ -0,0 +1,32 @@
+package recording
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for i := 0; i < 3; i++ {
+		if err := w.Write(Scan{Timestamp: i * 100, Step: 0.01, Distances: []int{1000, 5, 2000}}); err != nil {
+			t.Fatalf("Write failed: %v\n", err)
+		}
+	}
+	scans, err := ReadAll(&b)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v\n", err)
+	}
+	if len(scans) != 3 || scans[2].Timestamp != 200 {
+		t.Fatalf("Expected 3 scans ending at 200, got %v\n", scans)
+	}
+}
+
+func TestPoints(t *testing.T) {
+	s := Scan{StartAngle: 0, Step: math.Pi / 2, Distances: []int{1000, 5, 2000}}
+	p := s.Points()
+	if math.Abs(p[0][0]-1000) > 1e-9 || p[1][0] != 0 || p[1][1] != 0 || math.Abs(p[2][0]+2000) > 1e-9 {
+		t.Fatalf("Unexpected points %v\n", p)
+	}
+}
@@ -0,0 +1,118 @@
+// Package reflector finds retro-reflective targets in scans with intensity
+// and localizes the sensor against reflectors at known positions.
+package reflector
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Config holds the parameters of the detector.
+type Config struct {
+	// MinIntensity is the smallest intensity of a reflector return.
+	MinIntensity int
+	// MinRange is the smallest valid distance, smaller values are error codes.
+	MinRange int
+	// MaxJump splits a run of bright steps whose distances differ by more
+	// than this many millimeters.
+	MaxJump float64
+	// MinSteps is the smallest number of bright steps of a reflector.
+	MinSteps int
+	// MinWidth and MaxWidth bound the width of a reflector in millimeters.
+	MinWidth float64
+	MaxWidth float64
+}
+
+// DefaultConfig returns parameters for 50mm wide reflective tape.
+func DefaultConfig() Config {
+	return Config{
+		MinIntensity: 4000,
+		MinRange:     20,
+		MaxJump:      100.0,
+		MinSteps:     2,
+		MinWidth:     10.0,
+		MaxWidth:     150.0,
+	}
+}
+
+// Reflector is a detected reflector.
+type Reflector struct {
+	Position  mgl64.Vec2 // center in the sensor frame
+	Bearing   float64    // intensity weighted bearing in radians
+	Distance  float64    // intensity weighted distance in millimeters
+	Width     float64    // distance between the outermost bright points
+	Intensity int        // peak intensity
+	StartStep int
+	EndStep   int
+}
+
+// Detect finds the reflectors of a scan from GetDistanceAndIntensity.
+// startAngle is the bearing of the first step and step the angle between
+// steps, both in radians. The bearing and distance of a reflector are the
+// intensity weighted means over its steps, which locates its center with
+// a precision better than one step.
+func Detect(distances, intensities []int, startAngle, step float64, config Config) []Reflector {
+	reflectors := []Reflector{}
+	start := -1
+	flush := func(end int) {
+		if start >= 0 && end-start+1 >= config.MinSteps {
+			if r, ok := measure(distances, intensities, start, end, startAngle, step); ok &&
+				r.Width >= config.MinWidth && r.Width <= config.MaxWidth {
+				reflectors = append(reflectors, r)
+			}
+		}
+		start = -1
+	}
+	n := len(distances)
+	if len(intensities) < n {
+		n = len(intensities)
+	}
+	for i := 0; i < n; i++ {
+		bright := intensities[i] >= config.MinIntensity && distances[i] >= config.MinRange
+		if !bright {
+			flush(i - 1)
+			continue
+		}
+		if start >= 0 && math.Abs(float64(distances[i]-distances[i-1])) > config.MaxJump {
+			flush(i - 1)
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(n - 1)
+	return reflectors
+}
+
+func measure(distances, intensities []int, start, end int, startAngle, step float64) (Reflector, bool) {
+	var weight, bearing, dist float64
+	peak := 0
+	for i := start; i <= end; i++ {
+		w := float64(intensities[i])
+		weight += w
+		bearing += w * (startAngle + float64(i)*step)
+		dist += w * float64(distances[i])
+		if intensities[i] > peak {
+			peak = intensities[i]
+		}
+	}
+	if weight == 0 {
+		return Reflector{}, false
+	}
+	bearing /= weight
+	dist /= weight
+	polar := func(i int) mgl64.Vec2 {
+		theta := startAngle + float64(i)*step
+		return mgl64.Vec2{float64(distances[i]) * math.Cos(theta), float64(distances[i]) * math.Sin(theta)}
+	}
+	return Reflector{
+		Position:  mgl64.Vec2{dist * math.Cos(bearing), dist * math.Sin(bearing)},
+		Bearing:   bearing,
+		Distance:  dist,
+		Width:     polar(end).Sub(polar(start)).Len(),
+		Intensity: peak,
+		StartStep: start,
+		EndStep:   end,
+	}, true
+}
@@ -0,0 +1,148 @@
+package reflector
+
+import (
+	"errors"
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// LocalizerConfig holds the parameters of the landmark localizer.
+type LocalizerConfig struct {
+	// Gate is the largest distance in millimeters between a reflector and
+	// its landmark at the estimated pose.
+	Gate float64
+	// MinLandmarks is the smallest number of associated landmarks needed
+	// for a pose.
+	MinLandmarks int
+}
+
+// DefaultLocalizerConfig returns parameters suited for the URG-04LX.
+func DefaultLocalizerConfig() LocalizerConfig {
+	return LocalizerConfig{Gate: 200.0, MinLandmarks: 2}
+}
+
+// Estimate is a pose computed from reflectors.
+type Estimate struct {
+	Pose geom.Pose
+	// Associations maps every reflector to the index of its landmark,
+	// or -1 if it was not associated.
+	Associations []int
+	Matched      int     // number of associated reflectors
+	RMS          float64 // residual of the associated landmarks in millimeters
+}
+
+// Localizer computes the pose of the sensor from reflectors at known
+// positions in the map frame.
+type Localizer struct {
+	config    LocalizerConfig
+	landmarks []mgl64.Vec2
+}
+
+// NewLocalizer creates a localizer for the landmark positions.
+func NewLocalizer(landmarks []mgl64.Vec2, config LocalizerConfig) *Localizer {
+	return &Localizer{config: config, landmarks: landmarks}
+}
+
+// Track associates the reflectors with the landmarks closest to them at the
+// guessed pose and solves for the pose.
+func (l *Localizer) Track(reflectors []Reflector, guess geom.Pose) (Estimate, error) {
+	pose := guess
+	var est Estimate
+	// a second pass associates with the refined pose
+	for pass := 0; pass < 2; pass++ {
+		est = l.associate(reflectors, pose)
+		if est.Matched < l.config.MinLandmarks {
+			return est, errors.New("Not enough landmarks associated")
+		}
+		est = l.solve(reflectors, est.Associations)
+		pose = est.Pose
+	}
+	return est, nil
+}
+
+// Localize finds the pose without a prior by testing every pair of
+// reflectors against every pair of landmarks at the same distance and
+// keeping the hypothesis that explains the most reflectors.
+func (l *Localizer) Localize(reflectors []Reflector) (Estimate, error) {
+	best := Estimate{Matched: -1}
+	for a := 0; a < len(reflectors); a++ {
+		for b := a + 1; b < len(reflectors); b++ {
+			dr := reflectors[a].Position.Sub(reflectors[b].Position).Len()
+			for i := range l.landmarks {
+				for j := range l.landmarks {
+					if i == j {
+						continue
+					}
+					dl := l.landmarks[i].Sub(l.landmarks[j]).Len()
+					if math.Abs(dr-dl) > l.config.Gate {
+						continue
+					}
+					hypothesis := geom.Align(
+						[]mgl64.Vec2{reflectors[a].Position, reflectors[b].Position},
+						[]mgl64.Vec2{l.landmarks[i], l.landmarks[j]})
+					est := l.associate(reflectors, hypothesis)
+					if est.Matched < l.config.MinLandmarks {
+						continue
+					}
+					est = l.solve(reflectors, est.Associations)
+					if est.Matched > best.Matched || (est.Matched == best.Matched && est.RMS < best.RMS) {
+						best = est
+					}
+				}
+			}
+		}
+	}
+	if best.Matched < l.config.MinLandmarks {
+		return best, errors.New("No consistent landmark constellation found")
+	}
+	return best, nil
+}
+
+// associate assigns every reflector to the closest unused landmark within
+// the gate.
+func (l *Localizer) associate(reflectors []Reflector, pose geom.Pose) Estimate {
+	est := Estimate{Pose: pose, Associations: make([]int, len(reflectors))}
+	used := make([]bool, len(l.landmarks))
+	for k, r := range reflectors {
+		est.Associations[k] = -1
+		p := pose.Transform(r.Position)
+		best, bestDist := -1, l.config.Gate
+		for i, lm := range l.landmarks {
+			if d := p.Sub(lm).Len(); !used[i] && d <= bestDist {
+				best, bestDist = i, d
+			}
+		}
+		if best >= 0 {
+			used[best] = true
+			est.Associations[k] = best
+			est.Matched++
+		}
+	}
+	return est
+}
+
+// solve computes the least squares pose of the associated reflectors.
+func (l *Localizer) solve(reflectors []Reflector, associations []int) Estimate {
+	src := []mgl64.Vec2{}
+	dst := []mgl64.Vec2{}
+	for k, i := range associations {
+		if i >= 0 {
+			src = append(src, reflectors[k].Position)
+			dst = append(dst, l.landmarks[i])
+		}
+	}
+	est := Estimate{Associations: associations, Matched: len(src)}
+	if len(src) == 0 {
+		return est
+	}
+	est.Pose = geom.Align(src, dst)
+	sum := 0.0
+	for k := range src {
+		d := est.Pose.Transform(src[k]).Sub(dst[k])
+		sum += d.Dot(d)
+	}
+	est.RMS = math.Sqrt(sum / float64(len(src)))
+	return est
+}
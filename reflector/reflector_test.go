@@ -0,0 +1,80 @@
+package reflector
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+const (
+	startAngle = -2.09
+	step       = 2 * math.Pi / 1024
+)
+
+// scan renders a circular room of radius 4000 with 50mm reflectors at the
+// landmarks, seen from pose.
+func scan(pose geom.Pose, landmarks []mgl64.Vec2) ([]int, []int) {
+	distances := make([]int, 682)
+	intensities := make([]int, 682)
+	for i := range distances {
+		theta := pose.Theta + startAngle + float64(i)*step
+		dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+		o := pose.Vec()
+		b := o.Dot(dir)
+		r := -b + math.Sqrt(b*b-o.Dot(o)+4000*4000)
+		intensity := 1000
+		for _, lm := range landmarks {
+			v := lm.Sub(o)
+			along := v.Dot(dir)
+			across := math.Abs(v[0]*dir[1] - v[1]*dir[0])
+			if along > 0 && across <= 25 && along < r {
+				r = along
+				intensity = 8000
+			}
+		}
+		distances[i] = int(r)
+		intensities[i] = intensity
+	}
+	return distances, intensities
+}
+
+var landmarks = []mgl64.Vec2{{2000, 500}, {1500, -1500}, {-500, 2500}, {2500, 2000}}
+
+func TestDetect(t *testing.T) {
+	d, i := scan(geom.Pose{}, landmarks)
+	found := Detect(d, i, startAngle, step, DefaultConfig())
+	if len(found) != 4 {
+		t.Fatalf("Expected 4 reflectors, got %v\n", len(found))
+	}
+	for _, r := range found {
+		best := math.Inf(1)
+		for _, lm := range landmarks {
+			best = math.Min(best, r.Position.Sub(lm).Len())
+		}
+		if best > 30 {
+			t.Fatalf("Expected reflector %v near a landmark, off by %v\n", r.Position, best)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	truth := geom.Pose{X: 300, Y: -200, Theta: 0.25}
+	d, i := scan(truth, landmarks)
+	found := Detect(d, i, startAngle, step, DefaultConfig())
+	l := NewLocalizer(landmarks, DefaultLocalizerConfig())
+
+	est, err := l.Localize(found)
+	if err != nil {
+		t.Fatalf("Localize failed: %v\n", err)
+	}
+	if math.Hypot(est.Pose.X-truth.X, est.Pose.Y-truth.Y) > 30 || math.Abs(est.Pose.Theta-truth.Theta) > 0.02 {
+		t.Fatalf("Expected %v, got %v\n", truth, est.Pose)
+	}
+
+	tracked, err := l.Track(found, geom.Pose{X: 350, Y: -150, Theta: 0.2})
+	if err != nil || tracked.Matched != len(found) {
+		t.Fatalf("Expected to track all %v reflectors, got %v %v\n", len(found), tracked.Matched, err)
+	}
+}
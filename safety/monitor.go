@@ -0,0 +1,272 @@
+// Package safety monitors protective and warning zones around the sensor.
+//
+// A zone becomes violated once enough points fall inside it for a number
+// of consecutive scans and clears once it has been empty for a number of
+// scans. When scans stop arriving or too many consecutive scans fail, the
+// monitor enters a fail-safe state in which every protective zone is
+// reported as violated.
+package safety
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// State is the state of a zone.
+type State int
+
+const (
+	// Clear zones are free of obstacles.
+	Clear State = iota
+	// Violated zones contain an obstacle.
+	Violated
+)
+
+func (s State) String() string {
+	if s == Violated {
+		return "violated"
+	}
+	return "clear"
+}
+
+// Event reports a change of a zone or of the fail-safe state.
+type Event struct {
+	Time     time.Time
+	Zone     string // empty for fail-safe events
+	Level    Level
+	State    State
+	Points   int    // points inside the zone in the triggering scan
+	FailSafe bool   // set while the monitor is in the fail-safe state
+	Reason   string // why the fail-safe state was entered
+}
+
+// Config holds the parameters of the monitor.
+type Config struct {
+	Zones []Zone
+	// ViolateScans is the number of consecutive scans a zone must be
+	// occupied before it is reported as violated.
+	ViolateScans int
+	// ClearScans is the number of consecutive scans a zone must be empty
+	// before it is reported as clear.
+	ClearScans int
+	// ScanTimeout enters the fail-safe state when no scan arrived for so long.
+	ScanTimeout time.Duration
+	// MaxErrors enters the fail-safe state after that many consecutive
+	// failed scans, e.g. checksum errors.
+	MaxErrors int
+}
+
+// DefaultConfig returns a config without zones, debouncing over two scans
+// and failing safe after ten missed URG-04LX scans or three errors.
+func DefaultConfig() Config {
+	return Config{
+		ViolateScans: 2,
+		ClearScans:   2,
+		ScanTimeout:  10 * 100 * time.Millisecond,
+		MaxErrors:    3,
+	}
+}
+
+type zoneState struct {
+	state   State
+	pending int // consecutive scans disagreeing with state
+}
+
+// Monitor evaluates the zones on every scan. It is safe for concurrent
+// use, e.g. calling Safe while Run evaluates the scans.
+type Monitor struct {
+	mutex    sync.Mutex
+	config   Config
+	zones    []zoneState
+	lastScan time.Time
+	errors   int
+	failSafe bool
+}
+
+// NewMonitor creates a monitor with every zone clear. The scan timeout
+// starts counting at the first call.
+func NewMonitor(config Config) *Monitor {
+	return &Monitor{config: config, zones: make([]zoneState, len(config.Zones))}
+}
+
+// Update evaluates a scan, as returned by DataToCartesian, received at now.
+func (m *Monitor) Update(points []mgl64.Vec2, now time.Time) []Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	events := []Event{}
+	m.lastScan = now
+	m.errors = 0
+	if m.failSafe {
+		m.failSafe = false
+		events = append(events, Event{Time: now, State: Clear, Reason: "scans resumed"})
+	}
+	for i, z := range m.config.Zones {
+		n := z.count(points)
+		observed := Clear
+		if n >= z.MinPoints && n > 0 {
+			observed = Violated
+		}
+		zs := &m.zones[i]
+		if observed == zs.state {
+			zs.pending = 0
+			continue
+		}
+		zs.pending++
+		needed := m.config.ClearScans
+		if observed == Violated {
+			needed = m.config.ViolateScans
+		}
+		if zs.pending >= needed {
+			zs.state = observed
+			zs.pending = 0
+			events = append(events, Event{Time: now, Zone: z.Name, Level: z.Level, State: observed, Points: n})
+		}
+	}
+	return events
+}
+
+// ReportError records a failed scan, e.g. a checksum or timeout error
+// returned by GetDistance.
+func (m *Monitor) ReportError(err error, now time.Time) []Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.errors++
+	if m.config.MaxErrors > 0 && m.errors >= m.config.MaxErrors {
+		return m.enterFailSafe(now, fmt.Sprintf("%d consecutive scan errors, last: %v", m.errors, err))
+	}
+	return nil
+}
+
+// Check enters the fail-safe state if the last scan is older than the scan
+// timeout. It should be called periodically.
+func (m *Monitor) Check(now time.Time) []Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lastScan.IsZero() {
+		m.lastScan = now
+	}
+	if m.config.ScanTimeout > 0 && now.Sub(m.lastScan) > m.config.ScanTimeout {
+		return m.enterFailSafe(now, fmt.Sprintf("no scan for %v", now.Sub(m.lastScan)))
+	}
+	return nil
+}
+
+// enterFailSafe must be called while locked. It reports the fail-safe state
+// followed by the protective zones it violates. They stay violated after the
+// fail-safe state until they were clear for ClearScans scans, an obstacle
+// may have appeared meanwhile.
+func (m *Monitor) enterFailSafe(now time.Time, reason string) []Event {
+	if m.failSafe {
+		return nil
+	}
+	m.failSafe = true
+	events := []Event{{Time: now, Level: Protective, State: Violated, FailSafe: true, Reason: reason}}
+	for i, z := range m.config.Zones {
+		if z.Level != Protective {
+			continue
+		}
+		if m.zones[i].state != Violated {
+			events = append(events, Event{Time: now, Zone: z.Name, Level: z.Level, State: Violated, FailSafe: true, Reason: reason})
+		}
+		m.zones[i] = zoneState{state: Violated}
+	}
+	return events
+}
+
+// FailSafe reports whether the monitor is in the fail-safe state.
+func (m *Monitor) FailSafe() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.failSafe
+}
+
+// State returns the debounced state of a zone. Protective zones are
+// violated while in the fail-safe state.
+func (m *Monitor) State(zone string) State {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, z := range m.config.Zones {
+		if z.Name != zone {
+			continue
+		}
+		if m.failSafe && z.Level == Protective {
+			return Violated
+		}
+		return m.zones[i].state
+	}
+	return Clear
+}
+
+// Safe reports whether no protective zone is violated and the monitor is
+// not in the fail-safe state.
+func (m *Monitor) Safe() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.failSafe {
+		return false
+	}
+	for i, z := range m.config.Zones {
+		if z.Level == Protective && m.zones[i].state == Violated {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan is a scan result delivered to Run.
+type Scan struct {
+	Points []mgl64.Vec2
+	Err    error
+}
+
+// Run monitors a stream of scans until the context is cancelled or the
+// scan channel is closed, checking the scan timeout in between. The
+// returned channel is closed when Run stops.
+func (m *Monitor) Run(ctx context.Context, scans <-chan Scan) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		period := m.config.ScanTimeout / 4
+		if period <= 0 {
+			period = 100 * time.Millisecond
+		}
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		emit := func(events []Event) bool {
+			for _, e := range events {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+		for {
+			var events []Event
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok := <-scans:
+				if !ok {
+					return
+				}
+				if s.Err != nil {
+					events = m.ReportError(s.Err, time.Now())
+				} else {
+					events = m.Update(s.Points, time.Now())
+				}
+			case now := <-ticker.C:
+				events = m.Check(now)
+			}
+			if !emit(events) {
+				return
+			}
+		}
+	}()
+	return out
+}
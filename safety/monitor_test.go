@@ -0,0 +1,153 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Zones = []Zone{
+		{Name: "stop", Level: Protective, MinPoints: 3, Shape: Polygon{{0, -300}, {500, -300}, {500, 300}, {0, 300}}},
+		{Name: "slow", Level: Warning, MinPoints: 3, Shape: Sector{MinAngle: -0.5, MaxAngle: 0.5, MaxRadius: 1500}},
+	}
+	return cfg
+}
+
+func obstacle(x float64) []mgl64.Vec2 {
+	return []mgl64.Vec2{{x, -20}, {x, 0}, {x, 20}, {}, {3000, 0}}
+}
+
+func TestShapes(t *testing.T) {
+	poly := Polygon{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	if !poly.Contains(mgl64.Vec2{50, 50}) || poly.Contains(mgl64.Vec2{150, 50}) {
+		t.Fatalf("Polygon containment is wrong\n")
+	}
+	s := Sector{MinAngle: -0.5, MaxAngle: 0.5, MaxRadius: 1000}
+	if !s.Contains(mgl64.Vec2{500, 100}) || s.Contains(mgl64.Vec2{0, 500}) || s.Contains(mgl64.Vec2{1500, 0}) {
+		t.Fatalf("Sector containment is wrong\n")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	m := NewMonitor(testConfig())
+	now := time.Now()
+	if e := m.Update(obstacle(400), now); len(e) != 0 {
+		t.Fatalf("Expected no event after a single scan, got %v\n", e)
+	}
+	e := m.Update(obstacle(400), now)
+	if len(e) != 2 || m.Safe() {
+		t.Fatalf("Expected both zones violated, got %v\n", e)
+	}
+	m.Update(obstacle(1000), now)
+	e = m.Update(obstacle(1000), now)
+	if len(e) != 1 || e[0].Zone != "stop" || e[0].State != Clear || !m.Safe() {
+		t.Fatalf("Expected the protective zone to clear, got %v\n", e)
+	}
+	if m.State("slow") != Violated {
+		t.Fatalf("Expected the warning zone to stay violated\n")
+	}
+}
+
+func TestFailSafe(t *testing.T) {
+	m := NewMonitor(testConfig())
+	now := time.Now()
+	m.Update(obstacle(3000), now)
+	if e := m.Check(now.Add(2 * time.Second)); len(e) != 2 || !e[0].FailSafe || m.Safe() {
+		t.Fatalf("Expected a fail-safe on timeout, got %v\n", e)
+	}
+	if m.State("stop") != Violated {
+		t.Fatalf("Expected protective zones violated in fail-safe\n")
+	}
+	m.Update(obstacle(3000), now)
+	if m.FailSafe() || m.Safe() {
+		t.Fatalf("Expected the fail-safe to end but the protective zone to stay violated\n")
+	}
+	e := m.Update(obstacle(3000), now)
+	if len(e) != 1 || e[0].Zone != "stop" || e[0].State != Clear || !m.Safe() {
+		t.Fatalf("Expected the protective zone to clear after ClearScans scans, got %v\n", e)
+	}
+	for i := 0; i < 3; i++ {
+		m.ReportError(errors.New("checksum"), now)
+	}
+	if !m.FailSafe() {
+		t.Fatalf("Expected a fail-safe after repeated errors\n")
+	}
+}
+
+func TestFailSafeEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.Zones = append(cfg.Zones, Zone{Name: "left", Level: Protective, MinPoints: 1,
+		Shape: Polygon{{0, 500}, {500, 500}, {500, 1000}, {0, 1000}}})
+	m := NewMonitor(cfg)
+	now := time.Now()
+	m.Update(obstacle(400), now)
+	m.Update(obstacle(400), now)
+	if m.State("stop") != Violated || m.State("left") != Clear {
+		t.Fatalf("Expected only the stop zone violated\n")
+	}
+
+	// the violated zone is not reported again
+	e := m.Check(now.Add(2 * time.Second))
+	if len(e) != 2 || e[0].Zone != "" || !e[0].FailSafe ||
+		e[1].Zone != "left" || e[1].State != Violated || !e[1].FailSafe {
+		t.Fatalf("Expected the fail-safe and the left zone violated, got %v\n", e)
+	}
+	if e := m.Check(now.Add(3 * time.Second)); len(e) != 0 {
+		t.Fatalf("Expected no event while in the fail-safe state, got %v\n", e)
+	}
+
+	// every zone reported clear was reported violated before
+	e = m.Update(obstacle(3000), now)
+	if len(e) != 1 || e[0].State != Clear || e[0].Zone != "" {
+		t.Fatalf("Expected the fail-safe to end, got %v\n", e)
+	}
+	e = m.Update(obstacle(3000), now)
+	zones := map[string]State{}
+	for _, ev := range e {
+		zones[ev.Zone] = ev.State
+	}
+	if len(e) != 3 || zones["stop"] != Clear || zones["left"] != Clear || zones["slow"] != Clear {
+		t.Fatalf("Expected every zone to clear, got %v\n", e)
+	}
+}
+
+func TestFailSafeObstacle(t *testing.T) {
+	m := NewMonitor(testConfig())
+	now := time.Now()
+	m.Update(obstacle(3000), now)
+	m.Check(now.Add(2 * time.Second))
+	// the obstacle appeared during the outage
+	for i := 0; i < 3; i++ {
+		if m.Update(obstacle(400), now); m.Safe() {
+			t.Fatalf("Expected an obstacle after a fail-safe to keep the monitor unsafe at scan %d\n", i)
+		}
+	}
+}
+
+func TestRunConcurrentReads(t *testing.T) {
+	m := NewMonitor(testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scans := make(chan Scan)
+	events := m.Run(ctx, scans)
+	go func() {
+		for range events {
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if i%10 == 9 {
+			scans <- Scan{Err: errors.New("checksum")}
+		} else {
+			scans <- Scan{Points: obstacle(float64(300 + i*10))}
+		}
+		m.Safe()
+		m.State("stop")
+		m.FailSafe()
+	}
+	close(scans)
+}
@@ -0,0 +1,88 @@
+package safety
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Shape is an area in the sensor frame.
+type Shape interface {
+	Contains(p mgl64.Vec2) bool
+}
+
+// Polygon is a simple polygon given by its vertices in millimeters.
+type Polygon []mgl64.Vec2
+
+// Contains reports whether p lies inside the polygon using the even-odd rule.
+func (poly Polygon) Contains(p mgl64.Vec2) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Sector is an annular sector around the sensor. Angles are in radians
+// counter clockwise from the front of the sensor, radii in millimeters.
+type Sector struct {
+	MinAngle  float64
+	MaxAngle  float64
+	MinRadius float64
+	MaxRadius float64
+}
+
+// Contains reports whether p lies inside the sector.
+func (s Sector) Contains(p mgl64.Vec2) bool {
+	r := p.Len()
+	if r < s.MinRadius || r > s.MaxRadius {
+		return false
+	}
+	theta := math.Atan2(p[1], p[0])
+	return theta >= s.MinAngle && theta <= s.MaxAngle
+}
+
+// Level tells how severe a violation of a zone is.
+type Level int
+
+const (
+	// Warning zones slow the machine down.
+	Warning Level = iota
+	// Protective zones stop the machine.
+	Protective
+)
+
+func (l Level) String() string {
+	if l == Protective {
+		return "protective"
+	}
+	return "warning"
+}
+
+// Zone is a named area monitored for intrusions.
+type Zone struct {
+	Name  string
+	Level Level
+	Shape Shape
+	// MinPoints is the number of points inside the zone needed to count it
+	// as violated, which filters out single noisy returns.
+	MinPoints int
+}
+
+// count returns the number of valid points inside the zone.
+func (z Zone) count(points []mgl64.Vec2) int {
+	n := 0
+	for _, p := range points {
+		if p[0] == 0 && p[1] == 0 {
+			continue
+		}
+		if z.Shape.Contains(p) {
+			n++
+		}
+	}
+	return n
+}
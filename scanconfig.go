@@ -0,0 +1,211 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Spec is the specification of a sensor as reported by the PP command.
+type Spec struct {
+	Model       string // MODL
+	MinDistance int    // DMIN, millimeters
+	MaxDistance int    // DMAX, millimeters
+	Resolution  int    // ARES, steps per revolution
+	MinStep     int    // AMIN, first measurable step
+	MaxStep     int    // AMAX, last measurable step
+	FrontStep   int    // AFRT, step facing forward
+	ScanSpeed   int    // SCAN, revolutions per minute
+}
+
+// DefaultSpec returns the specification of the URG-04LX, used until
+// LoadSpec reads the one of the connected sensor.
+func DefaultSpec() Spec {
+	return Spec{"URG-04LX", DMIN, DMAX, ARES, AMIN, AMAX, AFRT, SCAN}
+}
+
+// ParseSpec parses the lines returned by PPCommand.
+func ParseSpec(lines []string) (Spec, error) {
+	spec := Spec{}
+	fields := map[string]*int{
+		"DMIN": &spec.MinDistance,
+		"DMAX": &spec.MaxDistance,
+		"ARES": &spec.Resolution,
+		"AMIN": &spec.MinStep,
+		"AMAX": &spec.MaxStep,
+		"AFRT": &spec.FrontStep,
+		"SCAN": &spec.ScanSpeed,
+	}
+	found := 0
+	for _, line := range lines {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] == "MODL" {
+			spec.Model = parts[1]
+			continue
+		}
+		field, ok := fields[parts[0]]
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Sscanf(parts[1], "%d", field); err != nil {
+			return spec, fmt.Errorf("Invalid %v value %q", parts[0], parts[1])
+		}
+		found++
+	}
+	if found != len(fields) {
+		return spec, errors.New("Specification is incomplete")
+	}
+	if spec.Resolution <= 0 || spec.MinStep > spec.MaxStep || spec.ScanSpeed <= 0 || spec.MinDistance > spec.MaxDistance {
+		return spec, errors.New("Specification is inconsistent")
+	}
+	return spec, nil
+}
+
+// StepToRadians returns the bearing of a step, zero facing forward and
+// counterclockwise positive.
+func (s Spec) StepToRadians(step int) float64 {
+	return float64(step-s.FrontStep) * 2 * math.Pi / float64(s.Resolution)
+}
+
+// StepToDegrees returns the bearing of a step in degrees.
+func (s Spec) StepToDegrees(step int) float64 {
+	return s.StepToRadians(step) * 180 / math.Pi
+}
+
+// RadiansToStep returns the step nearest to a bearing.
+func (s Spec) RadiansToStep(angle float64) int {
+	return s.FrontStep + int(math.Round(angle*float64(s.Resolution)/(2*math.Pi)))
+}
+
+// DegreesToStep returns the step nearest to a bearing in degrees.
+func (s Spec) DegreesToStep(angle float64) int {
+	return s.RadiansToStep(angle * math.Pi / 180)
+}
+
+// Encoding is the character encoding of the distances.
+type Encoding byte
+
+// The encodings of MD/GD and MS/GS.
+const (
+	ThreeCharEncoding Encoding = Encoding(threeEncoding)
+	TwoCharEncoding   Encoding = Encoding(twoEncoding)
+)
+
+// ErrInvalidScanConfig is matched by the errors of ScanConfig.Validate.
+var ErrInvalidScanConfig = errors.New("Invalid scan configuration")
+
+// ScanConfig are the parameters of the MD/MS and GD/GS commands.
+type ScanConfig struct {
+	StartStep     int
+	EndStep       int
+	ClusterCount  int // steps merged into one point, 0 or 1 for none
+	ScanInterval  int // scans skipped between two transmitted scans, MD/MS only
+	NumberOfScans int // 0 streams until QMCommand, MD/MS only
+	Encoding      Encoding
+	Characters    string // echoed by the sensor, up to 16 characters
+}
+
+// DefaultScanConfig scans the full field of view of a sensor.
+func DefaultScanConfig(spec Spec) ScanConfig {
+	return ScanConfig{StartStep: spec.MinStep, EndStep: spec.MaxStep, ClusterCount: 1, Encoding: ThreeCharEncoding}
+}
+
+// ScanConfigForAngles scans the steps nearest to the bearings from start
+// to end in radians.
+func ScanConfigForAngles(spec Spec, start, end float64) ScanConfig {
+	c := DefaultScanConfig(spec)
+	c.StartStep = spec.RadiansToStep(start)
+	c.EndStep = spec.RadiansToStep(end)
+	return c
+}
+
+// Validate checks the configuration against the specification of the
+// sensor and the field widths of the protocol.
+func (c ScanConfig) Validate(spec Spec) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %v", ErrInvalidScanConfig, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case c.StartStep < spec.MinStep || c.StartStep > spec.MaxStep:
+		return invalid("start step %d is outside %d-%d", c.StartStep, spec.MinStep, spec.MaxStep)
+	case c.EndStep < spec.MinStep || c.EndStep > spec.MaxStep:
+		return invalid("end step %d is outside %d-%d", c.EndStep, spec.MinStep, spec.MaxStep)
+	case c.EndStep < c.StartStep:
+		return invalid("end step %d is before start step %d", c.EndStep, c.StartStep)
+	case c.ClusterCount < 0 || c.ClusterCount > 99:
+		return invalid("cluster count %d is outside 0-99", c.ClusterCount)
+	case c.ScanInterval < 0 || c.ScanInterval > 9:
+		return invalid("scan interval %d is outside 0-9", c.ScanInterval)
+	case c.NumberOfScans < 0 || c.NumberOfScans > 99:
+		return invalid("number of scans %d is outside 0-99", c.NumberOfScans)
+	case c.Encoding != ThreeCharEncoding && c.Encoding != TwoCharEncoding:
+		return invalid("unknown encoding %q", byte(c.Encoding))
+	case len(c.Characters) > 16:
+		return invalid("string %q is longer than 16 characters", c.Characters)
+	}
+	return nil
+}
+
+// cluster is the number of steps per point.
+func (c ScanConfig) cluster() int {
+	if c.ClusterCount < 1 {
+		return 1
+	}
+	return c.ClusterCount
+}
+
+// points is the number of points of a scan.
+func (c ScanConfig) points() int {
+	return (c.EndStep-c.StartStep)/c.cluster() + 1
+}
+
+// Spec returns the specification used to validate scans and compute
+// angles.
+func (h *HokuyoLidar) Spec() Spec {
+	h.lock()
+	defer h.unlock()
+	return h.spec
+}
+
+// LoadSpec queries the specification of the connected sensor with the PP
+// command and uses it from now on.
+func (h *HokuyoLidar) LoadSpec() error {
+	lines, err := h.PPCommand("")
+	if err != nil {
+		return err
+	}
+	spec, err := ParseSpec(lines)
+	if err != nil {
+		return err
+	}
+	h.lock()
+	defer h.unlock()
+	h.spec = spec
+	return nil
+}
+
+// StartStream validates the configuration and starts an MD/MS stream.
+func (h *HokuyoLidar) StartStream(c ScanConfig) error {
+	h.lock()
+	defer h.unlock()
+	return h.startStream(c)
+}
+
+// RequestScan validates the configuration and requests a single scan
+// with GD/GS, read it with GetDistance.
+func (h *HokuyoLidar) RequestScan(c ScanConfig) error {
+	h.lock()
+	defer h.unlock()
+	return h.requestScan(c)
+}
+
+func encodingOf(three bool) Encoding {
+	if three {
+		return ThreeCharEncoding
+	}
+	return TwoCharEncoding
+}
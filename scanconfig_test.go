@@ -0,0 +1,96 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	lines := []string{
+		"MODL:URG-04LX(Hokuyo Automatic Co.,Ltd.)",
+		"DMIN:20", "DMAX:5600", "ARES:1024", "AMIN:44", "AMAX:725", "AFRT:384", "SCAN:600",
+	}
+	spec, err := ParseSpec(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := DefaultSpec(); spec.Resolution != want.Resolution || spec.MaxStep != want.MaxStep || spec.ScanSpeed != want.ScanSpeed {
+		t.Fatalf("Expected %+v, got %+v\n", want, spec)
+	}
+	if _, err := ParseSpec(lines[:4]); err == nil {
+		t.Fatal("Expected an incomplete specification to fail")
+	}
+	for _, bad := range []string{"SCAN:0", "SCAN:-600", "ARES:0", "AMIN:800", "DMIN:6000"} {
+		inconsistent := []string{}
+		for _, l := range lines {
+			if l[:5] == bad[:5] {
+				l = bad
+			}
+			inconsistent = append(inconsistent, l)
+		}
+		if _, err := ParseSpec(inconsistent); err == nil || err.Error() != "Specification is inconsistent" {
+			t.Fatalf("Expected %v to be rejected as inconsistent, got %v\n", bad, err)
+		}
+	}
+}
+
+func TestStepConversions(t *testing.T) {
+	spec := DefaultSpec()
+	if a := spec.StepToRadians(AFRT); a != 0 {
+		t.Fatalf("Expected the front step to face forward, got %v\n", a)
+	}
+	if s := spec.DegreesToStep(90); s != AFRT+256 {
+		t.Fatalf("Expected step %d at 90 degrees, got %d\n", AFRT+256, s)
+	}
+	if s := spec.RadiansToStep(spec.StepToRadians(AMIN)); s != AMIN {
+		t.Fatalf("Expected the conversions to round trip, got %d\n", s)
+	}
+	c := ScanConfigForAngles(spec, -math.Pi/2, math.Pi/2)
+	if c.StartStep != AFRT-256 || c.EndStep != AFRT+256 {
+		t.Fatalf("Expected steps %d-%d, got %d-%d\n", AFRT-256, AFRT+256, c.StartStep, c.EndStep)
+	}
+}
+
+func TestScanConfigValidate(t *testing.T) {
+	spec := DefaultSpec()
+	if err := DefaultScanConfig(spec).Validate(spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []ScanConfig{
+		{StartStep: 12345, EndStep: AMAX, Encoding: ThreeCharEncoding},
+		{StartStep: AMIN, EndStep: AMAX + 1, Encoding: ThreeCharEncoding},
+		{StartStep: 500, EndStep: 400, Encoding: ThreeCharEncoding},
+		{StartStep: AMIN, EndStep: AMAX, ClusterCount: 100, Encoding: ThreeCharEncoding},
+		{StartStep: AMIN, EndStep: AMAX, ScanInterval: 10, Encoding: ThreeCharEncoding},
+		{StartStep: AMIN, EndStep: AMAX, NumberOfScans: 100, Encoding: ThreeCharEncoding},
+		{StartStep: AMIN, EndStep: AMAX, Encoding: 'X'},
+		{StartStep: AMIN, EndStep: AMAX, Encoding: TwoCharEncoding, Characters: "0123456789abcdefg"},
+	} {
+		if err := c.Validate(spec); !errors.Is(err, ErrInvalidScanConfig) {
+			t.Fatalf("Expected %+v to be invalid, got %v\n", c, err)
+		}
+	}
+
+	h := NewHokuyoLidar("", 0)
+	h.state = StateLaserOn
+	if err := h.GDGSCommand(true, 12345, AMAX, 1, ""); !errors.Is(err, ErrInvalidScanConfig) {
+		t.Fatalf("Expected GD to reject step 12345, got %v\n", err)
+	}
+}
+
+func TestScanAngles(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	h.scan = ScanConfig{StartStep: AFRT, EndStep: AFRT + 9, ClusterCount: 3}
+	step := 2 * math.Pi / float64(ARES)
+	if a := h.StartAngle(); math.Abs(a-step) > 1e-12 {
+		t.Fatalf("Expected the first cluster centered one step left, got %v\n", a)
+	}
+	if s := h.AngularStep(); math.Abs(s-3*step) > 1e-12 {
+		t.Fatalf("Expected an angular step of three steps, got %v\n", s)
+	}
+	p := h.DataToCartesian([]int{1000, 10})
+	if p[1].Len() != 0 || math.Abs(p[0].Y()-1000*math.Sin(step)) > 1e-9 {
+		t.Fatalf("Unexpected points %v\n", p)
+	}
+}
@@ -0,0 +1,191 @@
+package scanmatch
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// CorrelativeConfig holds the parameters of the correlative scan matcher.
+type CorrelativeConfig struct {
+	// Resolution is the size of a fine grid cell in millimeters.
+	Resolution float64
+	// CoarseFactor is the number of fine cells per coarse cell along an axis.
+	CoarseFactor int
+	// Sigma is the standard deviation of the likelihood blur in millimeters.
+	Sigma float64
+	// WindowXY is the half width of the translation search in millimeters.
+	WindowXY float64
+	// WindowTheta is the half width of the rotation search in radians.
+	WindowTheta float64
+	// AngularStep is the rotation search step in radians. When zero it is
+	// chosen so that the furthest point moves by at most one cell.
+	AngularStep float64
+}
+
+// DefaultCorrelativeConfig returns parameters suited for the URG-04LX.
+func DefaultCorrelativeConfig() CorrelativeConfig {
+	return CorrelativeConfig{
+		Resolution:   20.0,
+		CoarseFactor: 8,
+		Sigma:        30.0,
+		WindowXY:     300.0,
+		WindowTheta:  0.2,
+	}
+}
+
+// CorrelativeResult reports the outcome of a correlative match.
+type CorrelativeResult struct {
+	Pose       geom.Pose  // transform taking the scan into the reference frame
+	Score      float64    // mean likelihood of the scan points at Pose
+	Covariance mgl64.Mat3 // covariance of (X, Y, Theta)
+}
+
+// CorrelativeMatcher searches a bounded pose window exhaustively for the
+// best alignment of a scan with a reference. A coarse max-filtered grid
+// bounds the score of whole blocks of translations so that only promising
+// blocks are evaluated on the fine grid.
+type CorrelativeMatcher struct {
+	config CorrelativeConfig
+	fine   *LikelihoodGrid
+	coarse *LikelihoodGrid
+}
+
+// NewCorrelativeMatcher builds the likelihood grids for the reference points.
+func NewCorrelativeMatcher(reference []mgl64.Vec2, config CorrelativeConfig) *CorrelativeMatcher {
+	ref := geom.Pose{}.TransformAll(reference)
+	return NewCorrelativeMatcherFromGrid(NewLikelihoodGrid(ref, config.Resolution, config.Sigma, config.WindowXY), config)
+}
+
+// NewCorrelativeMatcherFromGrid creates a matcher for an existing grid, such
+// as one rendered from a map. The grid resolution overrides the config.
+func NewCorrelativeMatcherFromGrid(grid *LikelihoodGrid, config CorrelativeConfig) *CorrelativeMatcher {
+	if config.CoarseFactor < 1 {
+		config.CoarseFactor = 1
+	}
+	config.Resolution = grid.Resolution
+	return &CorrelativeMatcher{
+		config: config,
+		fine:   grid,
+		coarse: grid.maxFilter(config.CoarseFactor),
+	}
+}
+
+type candidate struct {
+	theta  int
+	dx, dy int
+	score  float64
+}
+
+// Match searches the window around guess for the pose of the scan.
+func (m *CorrelativeMatcher) Match(scan []mgl64.Vec2, guess geom.Pose) (CorrelativeResult, error) {
+	points := geom.Pose{}.TransformAll(scan)
+	if len(points) == 0 {
+		return CorrelativeResult{Pose: guess}, errors.New("No points to match")
+	}
+	res := m.config.Resolution
+	k := m.config.CoarseFactor
+
+	dTheta := m.config.AngularStep
+	if dTheta <= 0 {
+		maxRange := 0.0
+		for _, p := range points {
+			maxRange = math.Max(maxRange, p.Len())
+		}
+		// a scan within res of the origin moves less than a cell for any
+		// rotation, the argument leaves [-1, 1] and the step is a half turn
+		dTheta = math.Acos(math.Max(-1, 1.0-res*res/(2.0*maxRange*maxRange)))
+	}
+	nTheta := int(math.Ceil(m.config.WindowTheta / dTheta))
+	nXY := int(math.Ceil(m.config.WindowXY / res))
+
+	// cell indices of the rotated scan for every searched angle
+	rotated := make([][][2]int, 2*nTheta+1)
+	for t := range rotated {
+		pose := geom.Pose{X: guess.X, Y: guess.Y, Theta: guess.Theta + float64(t-nTheta)*dTheta}
+		cells := make([][2]int, len(points))
+		for i, p := range points {
+			x, y := m.fine.Cell(pose.Transform(p))
+			cells[i] = [2]int{x, y}
+		}
+		rotated[t] = cells
+	}
+
+	candidates := []candidate{}
+	for t, cells := range rotated {
+		for dy := -nXY; dy <= nXY; dy += k {
+			for dx := -nXY; dx <= nXY; dx += k {
+				candidates = append(candidates, candidate{t, dx, dy, scoreCells(m.coarse, cells, dx, dy)})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	best := candidate{score: -1}
+	for _, c := range candidates {
+		if c.score <= best.score {
+			break
+		}
+		for dy := c.dy; dy < c.dy+k && dy <= nXY; dy++ {
+			for dx := c.dx; dx < c.dx+k && dx <= nXY; dx++ {
+				s := scoreCells(m.fine, rotated[c.theta], dx, dy)
+				if s > best.score {
+					best = candidate{c.theta, dx, dy, s}
+				}
+			}
+		}
+	}
+
+	toPose := func(c candidate) geom.Pose {
+		return geom.Pose{
+			X:     guess.X + float64(c.dx)*res,
+			Y:     guess.Y + float64(c.dy)*res,
+			Theta: geom.NormalizeAngle(guess.Theta + float64(c.theta-nTheta)*dTheta),
+		}
+	}
+
+	// weighted spread of the scores around the peak
+	var sum float64
+	var mean mgl64.Vec3
+	samples := []candidate{}
+	for t := best.theta - 2; t <= best.theta+2; t++ {
+		if t < 0 || t >= len(rotated) {
+			continue
+		}
+		for dy := best.dy - 2; dy <= best.dy+2; dy++ {
+			for dx := best.dx - 2; dx <= best.dx+2; dx++ {
+				c := candidate{t, dx, dy, scoreCells(m.fine, rotated[t], dx, dy)}
+				samples = append(samples, c)
+				sum += c.score
+				mean = mean.Add(mgl64.Vec3{float64(dx) * res, float64(dy) * res, float64(t) * dTheta}.Mul(c.score))
+			}
+		}
+	}
+	var cov mgl64.Mat3
+	if sum > 0 {
+		mean = mean.Mul(1.0 / sum)
+		for _, c := range samples {
+			d := mgl64.Vec3{float64(c.dx) * res, float64(c.dy) * res, float64(c.theta) * dTheta}.Sub(mean)
+			cov = cov.Add(d.OuterProd3(d).Mul(c.score / sum))
+		}
+	}
+	// a match can never be better than the discretisation of the search
+	cov.Set(0, 0, cov.At(0, 0)+res*res/12.0)
+	cov.Set(1, 1, cov.At(1, 1)+res*res/12.0)
+	cov.Set(2, 2, cov.At(2, 2)+dTheta*dTheta/12.0)
+
+	return CorrelativeResult{Pose: toPose(best), Score: best.score, Covariance: cov}, nil
+}
+
+func scoreCells(g *LikelihoodGrid, cells [][2]int, dx, dy int) float64 {
+	sum := 0.0
+	for _, c := range cells {
+		sum += g.At(c[0]+dx, c[1]+dy)
+	}
+	return sum / float64(len(cells))
+}
@@ -0,0 +1,46 @@
+package scanmatch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestCorrelativeMatch(t *testing.T) {
+	reference := room()
+	truth := geom.Pose{X: 160, Y: -120, Theta: 0.12}
+	scan := truth.Inverse().TransformAll(reference)
+
+	cfg := DefaultCorrelativeConfig()
+	m := NewCorrelativeMatcher(reference, cfg)
+	res, err := m.Match(scan, geom.Pose{})
+	if err != nil {
+		t.Fatalf("Match failed: %v\n", err)
+	}
+	if math.Abs(res.Pose.X-truth.X) > cfg.Resolution || math.Abs(res.Pose.Y-truth.Y) > cfg.Resolution || math.Abs(res.Pose.Theta-truth.Theta) > 0.01 {
+		t.Fatalf("Expected %v, got %v\n", truth, res.Pose)
+	}
+	if res.Covariance.At(0, 0) <= 0 || res.Covariance.At(2, 2) <= 0 {
+		t.Fatalf("Expected a positive covariance, got %v\n", res.Covariance)
+	}
+
+	again, _ := m.Match(scan, geom.Pose{})
+	if again.Pose != res.Pose {
+		t.Fatalf("Expected a deterministic result, got %v and %v\n", res.Pose, again.Pose)
+	}
+}
+
+func TestCorrelativeMatchShortRange(t *testing.T) {
+	// every point is closer than half a cell, no rotation can move them
+	reference := []mgl64.Vec2{{5, 0}, {0, 5}, {-5, 0}}
+	m := NewCorrelativeMatcher(reference, DefaultCorrelativeConfig())
+	res, err := m.Match(reference, geom.Pose{})
+	if err != nil {
+		t.Fatalf("Match failed: %v\n", err)
+	}
+	if math.IsNaN(res.Pose.Theta) || math.Hypot(res.Pose.X, res.Pose.Y) > DefaultCorrelativeConfig().Resolution {
+		t.Fatalf("Expected a pose near the origin, got %v\n", res.Pose)
+	}
+}
@@ -0,0 +1,47 @@
+package scanmatch
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// gridIndex is a spatial hash used for nearest neighbour lookups.
+type gridIndex struct {
+	points []mgl64.Vec2
+	cell   float64
+	cells  map[[2]int][]int
+}
+
+func newGridIndex(points []mgl64.Vec2, cell float64) *gridIndex {
+	if cell <= 0 {
+		cell = 100.0
+	}
+	g := &gridIndex{points: points, cell: cell, cells: map[[2]int][]int{}}
+	for i, p := range points {
+		k := g.key(p)
+		g.cells[k] = append(g.cells[k], i)
+	}
+	return g
+}
+
+func (g *gridIndex) key(p mgl64.Vec2) [2]int {
+	return [2]int{int(math.Floor(p[0] / g.cell)), int(math.Floor(p[1] / g.cell))}
+}
+
+// nearest returns the index of the closest point within one cell of p and
+// its distance, or -1 if there is none.
+func (g *gridIndex) nearest(p mgl64.Vec2) (int, float64) {
+	k := g.key(p)
+	best, bestDist := -1, math.Inf(1)
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			for _, i := range g.cells[[2]int{k[0] + dx, k[1] + dy}] {
+				if d := g.points[i].Sub(p).Len(); d < bestDist {
+					best, bestDist = i, d
+				}
+			}
+		}
+	}
+	return best, bestDist
+}
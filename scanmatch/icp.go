@@ -0,0 +1,186 @@
+// Package scanmatch estimates the rigid transform between two scans.
+package scanmatch
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Method selects the error metric minimised by ICP.
+type Method int
+
+const (
+	// PointToPoint minimises the distance between corresponding points.
+	PointToPoint Method = iota
+	// PointToLine minimises the distance of each point to the local surface
+	// of its correspondence in the reference scan.
+	PointToLine
+)
+
+// ICPConfig holds the parameters of the iterative closest point matcher.
+type ICPConfig struct {
+	Method Method
+	// MaxIterations bounds the number of iterations.
+	MaxIterations int
+	// MaxCorrespondenceDistance rejects pairs further apart in millimeters.
+	MaxCorrespondenceDistance float64
+	// TrimRatio is the fraction of the best correspondences kept in each
+	// iteration, the rest are rejected as outliers.
+	TrimRatio float64
+	// MinCorrespondences is the smallest number of pairs needed to solve.
+	MinCorrespondences int
+	// Tolerance stops the iteration once the update is smaller than this
+	// many millimeters of translation and milliradians of rotation.
+	Tolerance float64
+}
+
+// DefaultICPConfig returns parameters suited for the URG-04LX.
+func DefaultICPConfig() ICPConfig {
+	return ICPConfig{
+		Method:                    PointToLine,
+		MaxIterations:             50,
+		MaxCorrespondenceDistance: 300.0,
+		TrimRatio:                 0.9,
+		MinCorrespondences:        10,
+		Tolerance:                 0.1,
+	}
+}
+
+// Result reports the outcome of a scan match.
+type Result struct {
+	Pose       geom.Pose // transform taking the scan into the reference frame
+	Iterations int       // iterations that were run
+	Converged  bool      // whether the update fell below the tolerance
+	Error      float64   // RMS residual of the inliers in millimeters
+	Inliers    int       // number of correspondences used in the last step
+}
+
+type pair struct {
+	p, q, n mgl64.Vec2
+	dist    float64
+}
+
+// ICP aligns scan to reference starting from guess. Both point sets are
+// cartesian, zero vectors are ignored. The reference is expected in scan
+// order so that surface normals can be estimated from neighbouring points.
+func ICP(reference, scan []mgl64.Vec2, guess geom.Pose, cfg ICPConfig) (Result, error) {
+	ref := geom.Pose{}.TransformAll(reference)
+	src := geom.Pose{}.TransformAll(scan)
+	if len(ref) < cfg.MinCorrespondences || len(src) < cfg.MinCorrespondences {
+		return Result{Pose: guess}, errors.New("Not enough points to match")
+	}
+	normals := estimateNormals(ref)
+	index := newGridIndex(ref, cfg.MaxCorrespondenceDistance)
+
+	res := Result{Pose: guess}
+	for res.Iterations < cfg.MaxIterations {
+		res.Iterations++
+		pairs := []pair{}
+		for _, p := range src {
+			moved := res.Pose.Transform(p)
+			j, dist := index.nearest(moved)
+			if j < 0 || dist > cfg.MaxCorrespondenceDistance {
+				continue
+			}
+			pairs = append(pairs, pair{moved, ref[j], normals[j], dist})
+		}
+		pairs = trim(pairs, cfg.TrimRatio)
+		if len(pairs) < cfg.MinCorrespondences {
+			return res, errors.New("Not enough correspondences")
+		}
+
+		var step geom.Pose
+		var ok bool
+		if cfg.Method == PointToLine {
+			step, ok = solvePointToLine(pairs)
+		} else {
+			step, ok = solvePointToPoint(pairs), true
+		}
+		if !ok {
+			return res, errors.New("Degenerate correspondences")
+		}
+		res.Pose = step.Compose(res.Pose)
+		res.Inliers = len(pairs)
+		res.Error = rms(pairs, step, cfg.Method)
+
+		if math.Hypot(step.X, step.Y) < cfg.Tolerance && math.Abs(step.Theta)*1000.0 < cfg.Tolerance {
+			res.Converged = true
+			break
+		}
+	}
+	return res, nil
+}
+
+func trim(pairs []pair, ratio float64) []pair {
+	if ratio <= 0 || ratio >= 1 {
+		return pairs
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].dist < pairs[j].dist })
+	return pairs[:int(math.Ceil(float64(len(pairs))*ratio))]
+}
+
+// solvePointToPoint returns the closed form least squares transform.
+func solvePointToPoint(pairs []pair) geom.Pose {
+	src := make([]mgl64.Vec2, len(pairs))
+	dst := make([]mgl64.Vec2, len(pairs))
+	for i, pr := range pairs {
+		src[i], dst[i] = pr.p, pr.q
+	}
+	return geom.Align(src, dst)
+}
+
+// solvePointToLine linearises the rotation and solves the 3x3 normal
+// equations of the point to line error.
+func solvePointToLine(pairs []pair) (geom.Pose, bool) {
+	var ata mgl64.Mat3
+	var atb mgl64.Vec3
+	for _, pr := range pairs {
+		j := mgl64.Vec3{pr.n[0], pr.n[1], pr.n[1]*pr.p[0] - pr.n[0]*pr.p[1]}
+		r := pr.n.Dot(pr.q.Sub(pr.p))
+		for a := 0; a < 3; a++ {
+			for b := 0; b < 3; b++ {
+				ata.Set(a, b, ata.At(a, b)+j[a]*j[b])
+			}
+			atb[a] += j[a] * r
+		}
+	}
+	if math.Abs(ata.Det()) < 1e-9 {
+		return geom.Pose{}, false
+	}
+	x := ata.Inv().Mul3x1(atb)
+	return geom.Pose{X: x[0], Y: x[1], Theta: x[2]}, true
+}
+
+func rms(pairs []pair, step geom.Pose, method Method) float64 {
+	sum := 0.0
+	for _, pr := range pairs {
+		d := step.Transform(pr.p).Sub(pr.q)
+		if method == PointToLine {
+			e := d.Dot(pr.n)
+			sum += e * e
+		} else {
+			sum += d.Dot(d)
+		}
+	}
+	return math.Sqrt(sum / float64(len(pairs)))
+}
+
+// estimateNormals computes the normal of each point from its neighbours.
+func estimateNormals(points []mgl64.Vec2) []mgl64.Vec2 {
+	normals := make([]mgl64.Vec2, len(points))
+	for i := range points {
+		a := points[max(i-1, 0)]
+		b := points[min(i+1, len(points)-1)]
+		d := b.Sub(a)
+		if d.Len() == 0 {
+			normals[i] = mgl64.Vec2{1, 0}
+			continue
+		}
+		normals[i] = mgl64.Vec2{-d[1], d[0]}.Normalize()
+	}
+	return normals
+}
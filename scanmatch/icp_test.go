@@ -0,0 +1,61 @@
+package scanmatch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// room returns the points of a rectangular room seen from inside, sorted
+// by bearing as a scan would be.
+func room() []mgl64.Vec2 {
+	points := []mgl64.Vec2{}
+	for i := 0; i < 682; i++ {
+		theta := -2.09 + float64(i)*(4.18/682.0)
+		dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+		// walls at x = 3000, y = 2000, y = -1500, x = -1000
+		r := math.Inf(1)
+		if dir[0] > 0 {
+			r = math.Min(r, 3000/dir[0])
+		} else if dir[0] < 0 {
+			r = math.Min(r, -1000/dir[0])
+		}
+		if dir[1] > 0 {
+			r = math.Min(r, 2000/dir[1])
+		} else if dir[1] < 0 {
+			r = math.Min(r, -1500/dir[1])
+		}
+		points = append(points, dir.Mul(r))
+	}
+	return points
+}
+
+func testICP(t *testing.T, method Method, tolerance float64) {
+	reference := room()
+	truth := geom.Pose{X: 80, Y: -40, Theta: 0.05}
+	scan := truth.Inverse().TransformAll(reference)
+
+	cfg := DefaultICPConfig()
+	cfg.Method = method
+	res, err := ICP(reference, scan, geom.Pose{}, cfg)
+	if err != nil {
+		t.Fatalf("ICP failed: %v\n", err)
+	}
+	if !res.Converged {
+		t.Fatalf("Expected ICP to converge after %v iterations\n", res.Iterations)
+	}
+	if math.Abs(res.Pose.X-truth.X) > tolerance || math.Abs(res.Pose.Y-truth.Y) > tolerance || math.Abs(res.Pose.Theta-truth.Theta) > tolerance/1000.0 {
+		t.Fatalf("Expected %v, got %v\n", truth, res.Pose)
+	}
+}
+
+func TestICPPointToPoint(t *testing.T) {
+	// point to point converges slowly when points slide along walls
+	testICP(t, PointToPoint, 10)
+}
+
+func TestICPPointToLine(t *testing.T) {
+	testICP(t, PointToLine, 1)
+}
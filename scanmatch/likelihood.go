@@ -0,0 +1,128 @@
+package scanmatch
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// LikelihoodGrid is a raster of the probability of observing a point at
+// each cell, built by blurring the points of a reference scan or map.
+type LikelihoodGrid struct {
+	Resolution float64    // size of a cell in millimeters
+	Origin     mgl64.Vec2 // world position of the corner of cell (0, 0)
+	Width      int        // number of cells along x
+	Height     int        // number of cells along y
+	Cells      []float64  // row major likelihoods in [0, 1]
+}
+
+// NewLikelihoodGrid rasterises the points with a gaussian of standard
+// deviation sigma millimeters. margin extends the grid beyond the bounding
+// box of the points so that shifted scans still land inside it.
+func NewLikelihoodGrid(points []mgl64.Vec2, resolution, sigma, margin float64) *LikelihoodGrid {
+	min := mgl64.Vec2{math.Inf(1), math.Inf(1)}
+	max := mgl64.Vec2{math.Inf(-1), math.Inf(-1)}
+	for _, p := range points {
+		min = mgl64.Vec2{math.Min(min[0], p[0]), math.Min(min[1], p[1])}
+		max = mgl64.Vec2{math.Max(max[0], p[0]), math.Max(max[1], p[1])}
+	}
+	if len(points) == 0 {
+		min, max = mgl64.Vec2{}, mgl64.Vec2{}
+	}
+	reach := 3.0 * sigma
+	margin += reach
+	g := &LikelihoodGrid{
+		Resolution: resolution,
+		Origin:     min.Sub(mgl64.Vec2{margin, margin}),
+		Width:      int(math.Ceil((max[0]-min[0]+2*margin)/resolution)) + 1,
+		Height:     int(math.Ceil((max[1]-min[1]+2*margin)/resolution)) + 1,
+	}
+	g.Cells = make([]float64, g.Width*g.Height)
+
+	r := int(math.Ceil(reach / resolution))
+	for _, p := range points {
+		cx, cy := g.Cell(p)
+		for y := cy - r; y <= cy+r; y++ {
+			for x := cx - r; x <= cx+r; x++ {
+				if !g.Contains(x, y) {
+					continue
+				}
+				d := g.Center(x, y).Sub(p).Len()
+				v := math.Exp(-d * d / (2.0 * sigma * sigma))
+				if v > g.Cells[y*g.Width+x] {
+					g.Cells[y*g.Width+x] = v
+				}
+			}
+		}
+	}
+	return g
+}
+
+// Cell returns the indices of the cell containing p.
+func (g *LikelihoodGrid) Cell(p mgl64.Vec2) (int, int) {
+	return int(math.Floor((p[0] - g.Origin[0]) / g.Resolution)),
+		int(math.Floor((p[1] - g.Origin[1]) / g.Resolution))
+}
+
+// Center returns the world position of the center of a cell.
+func (g *LikelihoodGrid) Center(x, y int) mgl64.Vec2 {
+	return mgl64.Vec2{
+		g.Origin[0] + (float64(x)+0.5)*g.Resolution,
+		g.Origin[1] + (float64(y)+0.5)*g.Resolution,
+	}
+}
+
+// Contains reports whether the cell lies inside the grid.
+func (g *LikelihoodGrid) Contains(x, y int) bool {
+	return x >= 0 && y >= 0 && x < g.Width && y < g.Height
+}
+
+// At returns the likelihood of a cell, or 0 outside of the grid.
+func (g *LikelihoodGrid) At(x, y int) float64 {
+	if !g.Contains(x, y) {
+		return 0
+	}
+	return g.Cells[y*g.Width+x]
+}
+
+// Score returns the mean likelihood of the points.
+func (g *LikelihoodGrid) Score(points []mgl64.Vec2) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, p := range points {
+		sum += g.At(g.Cell(p))
+	}
+	return sum / float64(len(points))
+}
+
+// maxFilter returns a grid where each cell holds the largest value of the
+// size by size block of cells starting at it. Scores computed on the
+// filtered grid are an upper bound of the scores of every translation
+// inside the block.
+func (g *LikelihoodGrid) maxFilter(size int) *LikelihoodGrid {
+	rows := &LikelihoodGrid{Resolution: g.Resolution, Origin: g.Origin, Width: g.Width, Height: g.Height}
+	rows.Cells = make([]float64, len(g.Cells))
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			m := 0.0
+			for a := 0; a < size; a++ {
+				m = math.Max(m, g.At(x+a, y))
+			}
+			rows.Cells[y*g.Width+x] = m
+		}
+	}
+	out := &LikelihoodGrid{Resolution: g.Resolution, Origin: g.Origin, Width: g.Width, Height: g.Height}
+	out.Cells = make([]float64, len(g.Cells))
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			m := 0.0
+			for b := 0; b < size; b++ {
+				m = math.Max(m, rows.At(x, y+b))
+			}
+			out.Cells[y*g.Width+x] = m
+		}
+	}
+	return out
+}
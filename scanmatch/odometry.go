@@ -0,0 +1,51 @@
+package scanmatch
+
+import (
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Odometry accumulates the motion of the sensor by matching every scan
+// against the previous one.
+type Odometry struct {
+	config   ICPConfig
+	pose     geom.Pose
+	velocity geom.Pose
+	previous []mgl64.Vec2
+}
+
+// NewOdometry creates an odometry estimator starting at the origin.
+func NewOdometry(config ICPConfig) *Odometry {
+	return &Odometry{config: config}
+}
+
+// Update matches the points of a new scan, as returned by DataToCartesian,
+// against the previous scan and returns the accumulated pose. The last
+// motion is used as the initial guess. The first scan only initialises the
+// estimator. If the match fails the pose is left unchanged.
+func (o *Odometry) Update(points []mgl64.Vec2) (geom.Pose, Result, error) {
+	if o.previous == nil {
+		o.previous = points
+		return o.pose, Result{Converged: true}, nil
+	}
+	res, err := ICP(o.previous, points, o.velocity, o.config)
+	if err != nil {
+		return o.pose, res, err
+	}
+	o.velocity = res.Pose
+	o.pose = o.pose.Compose(res.Pose)
+	o.previous = points
+	return o.pose, res, nil
+}
+
+// Pose returns the accumulated pose.
+func (o *Odometry) Pose() geom.Pose {
+	return o.pose
+}
+
+// Reset moves the estimator back to the origin and forgets the last scan.
+func (o *Odometry) Reset() {
+	o.pose = geom.Pose{}
+	o.velocity = geom.Pose{}
+	o.previous = nil
+}
@@ -0,0 +1,105 @@
+// Package segment splits a lidar scan into clusters of neighbouring points.
+package segment
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Cluster is a group of consecutive scan points that belong to the same object.
+type Cluster struct {
+	Indices  []int        // indices of the points in the original scan
+	Points   []mgl64.Vec2 // cartesian points of the cluster
+	Centroid mgl64.Vec2   // mean of the points
+	Extent   float64      // distance between the first and last point
+	Min      mgl64.Vec2   // lower left corner of the bounding box
+	Max      mgl64.Vec2   // upper right corner of the bounding box
+}
+
+// Config holds the parameters of the adaptive breakpoint detector.
+type Config struct {
+	// Lambda is the worst case incidence angle of a surface, in radians,
+	// that is still considered continuous.
+	Lambda float64
+	// Sigma is the range noise of the sensor in millimeters.
+	Sigma float64
+	// MinPoints is the smallest number of points a cluster may have.
+	MinPoints int
+}
+
+// DefaultConfig returns parameters suited for the URG-04LX.
+func DefaultConfig() Config {
+	return Config{
+		Lambda:    10.0 * math.Pi / 180.0,
+		Sigma:     10.0,
+		MinPoints: 3,
+	}
+}
+
+// Segment splits the points of a scan into clusters. The points are expected
+// in scan order, as returned by DataToCartesian, where a zero vector marks a
+// step without a valid return. step is the angle between two consecutive
+// points in radians, as returned by AngularStep.
+// Two neighbouring points are split when their distance exceeds the adaptive
+// breakpoint threshold r*sin(step)/sin(lambda-step) + 3*sigma.
+func Segment(points []mgl64.Vec2, step float64, cfg Config) []Cluster {
+	clusters := []Cluster{}
+	current := []int{}
+
+	flush := func() {
+		if len(current) >= cfg.MinPoints && len(current) > 0 {
+			clusters = append(clusters, newCluster(points, current))
+		}
+		current = []int{}
+	}
+
+	for i, p := range points {
+		if isInvalid(p) {
+			flush()
+			continue
+		}
+		if len(current) > 0 {
+			prev := points[current[len(current)-1]]
+			gap := float64(i - current[len(current)-1])
+			if p.Sub(prev).Len() > breakpointDistance(prev.Len(), step*gap, cfg) {
+				flush()
+			}
+		}
+		current = append(current, i)
+	}
+	flush()
+
+	return clusters
+}
+
+func breakpointDistance(r, step float64, cfg Config) float64 {
+	denom := math.Sin(cfg.Lambda - step)
+	if denom <= 0 {
+		return math.Inf(1)
+	}
+	return r*math.Sin(step)/denom + 3.0*cfg.Sigma
+}
+
+func isInvalid(p mgl64.Vec2) bool {
+	return p[0] == 0 && p[1] == 0
+}
+
+func newCluster(points []mgl64.Vec2, indices []int) Cluster {
+	c := Cluster{
+		Indices: indices,
+		Points:  make([]mgl64.Vec2, 0, len(indices)),
+		Min:     mgl64.Vec2{math.Inf(1), math.Inf(1)},
+		Max:     mgl64.Vec2{math.Inf(-1), math.Inf(-1)},
+	}
+	for _, i := range indices {
+		p := points[i]
+		c.Points = append(c.Points, p)
+		c.Centroid = c.Centroid.Add(p)
+		c.Min = mgl64.Vec2{math.Min(c.Min[0], p[0]), math.Min(c.Min[1], p[1])}
+		c.Max = mgl64.Vec2{math.Max(c.Max[0], p[0]), math.Max(c.Max[1], p[1])}
+	}
+	c.Centroid = c.Centroid.Mul(1.0 / float64(len(indices)))
+	c.Extent = c.Points[len(c.Points)-1].Sub(c.Points[0]).Len()
+	return c
+}
@@ -0,0 +1,49 @@
+package segment
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func polar(r, theta float64) mgl64.Vec2 {
+	return mgl64.Vec2{r * math.Cos(theta), r * math.Sin(theta)}
+}
+
+func TestSegmentSplitsOnRangeJump(t *testing.T) {
+	step := 360.0 / 1024.0 * math.Pi / 180.0
+	points := []mgl64.Vec2{}
+	for i := 0; i < 10; i++ {
+		points = append(points, polar(1000, float64(i)*step))
+	}
+	for i := 10; i < 20; i++ {
+		points = append(points, polar(3000, float64(i)*step))
+	}
+	clusters := Segment(points, step, DefaultConfig())
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %v\n", len(clusters))
+	}
+	if len(clusters[0].Indices) != 10 || clusters[1].Indices[0] != 10 {
+		t.Fatalf("Expected the split at index 10, got %v\n", clusters[1].Indices)
+	}
+	if c := clusters[0].Centroid.Len(); math.Abs(c-1000) > 5 {
+		t.Fatalf("Expected centroid near 1000mm, got %v\n", c)
+	}
+}
+
+func TestSegmentSplitsOnInvalidPoints(t *testing.T) {
+	step := 360.0 / 1024.0 * math.Pi / 180.0
+	points := []mgl64.Vec2{}
+	for i := 0; i < 12; i++ {
+		if i == 6 {
+			points = append(points, mgl64.Vec2{})
+			continue
+		}
+		points = append(points, polar(1000, float64(i)*step))
+	}
+	clusters := Segment(points, step, DefaultConfig())
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %v\n", len(clusters))
+	}
+}
@@ -0,0 +1,530 @@
+// Package server exposes a lidar over HTTP: sensor information and control
+// as JSON endpoints, and a WebSocket streaming the scans to clients such as
+// the built-in canvas viewer.
+//
+// Endpoints:
+//
+//	GET  /                      canvas viewer
+//	GET  /api/version           VV command
+//	GET  /api/specification     PP command
+//	GET  /api/state             II command
+//	POST /api/laser             {"on": true}
+//	POST /api/motor             {"speed": 0..10}
+//	POST /api/sensitivity       {"high": true}
+//	POST /api/stream/start      {"start": 44, "end": 725, "cluster": 1, "interval": 0}
+//	POST /api/stream/stop
+//	GET  /api/stream            {"streaming": true, "error": "last scan error"}
+//	GET  /ws?format=json|binary WebSocket of scans
+//
+// Control requests must be POSTs with Content-Type: application/json and
+// WebSocket upgrades must come from the same origin, so that other web pages
+// can't control the sensor.
+//
+// A failed scan is reported on /api/stream and to JSON clients as
+// {"error": "..."}. The stream backs off while scans fail and stops when
+// the sensor can't stream anymore, e.g. after it was disconnected.
+//
+// JSON scans use the recording.Scan format. Binary scans are little endian: uint32 timestamp, float32 start angle,
+// float32 step, uint16 count, then count uint16 distances.
+package server
+
+import (
+	"bytes"
+	"embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+)
+
+//go:embed static
+var static embed.FS
+
+// Sensor is the part of *HokuyoLidar used by the server.
+type Sensor interface {
+	VVCommand(chars string) ([]string, error)
+	PPCommand(chars string) ([]string, error)
+	IICommand(chars string) ([]string, error)
+	BMCommand(chars string) error
+	QMCommand(chars string) error
+	CRCommand(chars string) error
+	HSCommand(highMode bool, chars string) error
+	MDMSCmd(three bool, startStep, endStep, clusterCount, scanInterval, numberOfScans int, characters string) error
+	GetDistance() ([]int, int, error)
+	StartAngle() float64
+	AngularStep() float64
+}
+
+// StreamConfig are the parameters of the MD command started by the server.
+type StreamConfig struct {
+	Start    int `json:"start"`
+	End      int `json:"end"`
+	Cluster  int `json:"cluster"`
+	Interval int `json:"interval"`
+}
+
+// DefaultStreamConfig streams the full field of view of the URG-04LX.
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{Start: lidar.AMIN, End: lidar.AMAX, Cluster: 1}
+}
+
+type client struct {
+	binary bool
+	send   chan []byte
+	pong   chan []byte
+}
+
+// Server serves one sensor. Control commands are rejected while a stream
+// is running, queries are answered as far as the sensor allows.
+type Server struct {
+	sensor Sensor
+
+	mutex     sync.Mutex // guards the stream
+	streaming bool
+	stop      chan struct{}
+	done      chan struct{}
+	lastErr   error // of the last scan, nil after a good one
+
+	clientsMutex sync.Mutex
+	clients      map[*client]struct{}
+}
+
+// New creates a server for a connected sensor.
+func New(sensor Sensor) *Server {
+	return &Server{sensor: sensor, clients: map[*client]struct{}{}}
+}
+
+// Handler returns the HTTP handler of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	files, _ := fs.Sub(static, "static")
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	mux.HandleFunc("/api/version", s.info(s.sensor.VVCommand))
+	mux.HandleFunc("/api/specification", s.info(s.sensor.PPCommand))
+	mux.HandleFunc("/api/state", s.info(s.sensor.IICommand))
+	mux.HandleFunc("/api/laser", s.handleLaser)
+	mux.HandleFunc("/api/motor", s.handleMotor)
+	mux.HandleFunc("/api/sensitivity", s.handleSensitivity)
+	mux.HandleFunc("/api/stream/start", s.handleStreamStart)
+	mux.HandleFunc("/api/stream/stop", s.handleStreamStop)
+	mux.HandleFunc("/api/stream", s.handleStreamStatus)
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	return mux
+}
+
+// parseInfo turns the "KEY:value" lines of VV, PP and II into a map.
+func parseInfo(lines []string) map[string]string {
+	info := map[string]string{}
+	for _, l := range lines {
+		parts := strings.SplitN(strings.TrimSpace(l), ":", 2)
+		if len(parts) == 2 {
+			info[parts[0]] = parts[1]
+		}
+	}
+	return info
+}
+
+func (s *Server) info(query func(string) ([]string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("Use GET"))
+			return
+		}
+		// the sensor decides which queries it answers during a stream
+		lines, err := query("")
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		writeJSON(w, parseInfo(lines))
+	}
+}
+
+// command runs a control command, which would stop the stream.
+func (s *Server) command(fn func() error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.streaming {
+		return lidar.ErrStreaming
+	}
+	return fn()
+}
+
+func (s *Server) handleLaser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		On bool `json:"on"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	err := s.command(func() error {
+		if req.On {
+			return s.sensor.BMCommand("")
+		}
+		return s.sensor.QMCommand("")
+	})
+	respond(w, err)
+}
+
+func (s *Server) handleMotor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Speed int `json:"speed"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Speed < 0 || req.Speed > 10 {
+		writeError(w, http.StatusBadRequest, errors.New("Speed ratio must be between 0 and 10"))
+		return
+	}
+	respond(w, s.command(func() error {
+		return s.sensor.CRCommand(fmt.Sprintf("%02d", req.Speed))
+	}))
+}
+
+func (s *Server) handleSensitivity(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		High bool `json:"high"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	respond(w, s.command(func() error {
+		return s.sensor.HSCommand(req.High, "")
+	}))
+}
+
+func (s *Server) handleStreamStart(w http.ResponseWriter, r *http.Request) {
+	cfg := DefaultStreamConfig()
+	if !decode(w, r, &cfg) {
+		return
+	}
+	respond(w, s.StartStream(cfg))
+}
+
+func (s *Server) handleStreamStop(w http.ResponseWriter, r *http.Request) {
+	if !postJSON(w, r) {
+		return
+	}
+	respond(w, s.StopStream())
+}
+
+func (s *Server) handleStreamStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Use GET"))
+		return
+	}
+	var status struct {
+		Streaming bool   `json:"streaming"`
+		Error     string `json:"error,omitempty"`
+	}
+	s.mutex.Lock()
+	status.Streaming = s.streaming
+	if s.lastErr != nil {
+		status.Error = s.lastErr.Error()
+	}
+	s.mutex.Unlock()
+	writeJSON(w, status)
+}
+
+// StartStream switches the laser on, starts continuous scanning and
+// broadcasts every scan to the WebSocket clients.
+func (s *Server) StartStream(cfg StreamConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.streaming {
+		return lidar.ErrStreaming
+	}
+	if err := s.sensor.MDMSCmd(true, cfg.Start, cfg.End, cfg.Cluster, cfg.Interval, 0, ""); err != nil {
+		return err
+	}
+	s.streaming = true
+	s.lastErr = nil
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.stream(s.stop, s.done)
+	return nil
+}
+
+// StopStream stops the stream and switches the laser off. If the scan being
+// read doesn't complete within stopTimeout, e.g. because the sensor stopped
+// sending, it returns an error matching lidar.ErrTimeout and the stream keeps
+// running until StopStream is called again.
+func (s *Server) StopStream() error {
+	s.mutex.Lock()
+	if !s.streaming {
+		s.mutex.Unlock()
+		return errors.New("Sensor is not streaming")
+	}
+	select {
+	case <-s.stop:
+		// a previous StopStream timed out
+	default:
+		close(s.stop)
+	}
+	done := s.done
+	s.mutex.Unlock()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		return fmt.Errorf("Stream did not stop within %v: %w", stopTimeout, lidar.ErrTimeout)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.streaming {
+		// stopped by a concurrent StopStream
+		return nil
+	}
+	s.streaming = false
+	return s.sensor.QMCommand("")
+}
+
+// The backoff after a failed scan doubles up to maxBackoff.
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// stopTimeout bounds the wait of StopStream for the scan being read.
+var stopTimeout = 3 * time.Second
+
+func (s *Server) stream(stop, done chan struct{}) {
+	defer close(done)
+	backoff := minBackoff
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		distances, timestamp, err := s.sensor.GetDistance()
+		if err != nil {
+			s.setError(err)
+			s.broadcastError(err)
+			if errors.Is(err, lidar.ErrInvalidState) || errors.Is(err, lidar.ErrNotConnected) {
+				s.abort(stop)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		s.setError(nil)
+		s.broadcast(recording.Scan{
+			Timestamp:  timestamp,
+			StartAngle: s.sensor.StartAngle(),
+			Step:       s.sensor.AngularStep(),
+			Distances:  distances,
+		})
+	}
+}
+
+func (s *Server) setError(err error) {
+	s.mutex.Lock()
+	s.lastErr = err
+	s.mutex.Unlock()
+}
+
+// abort ends a stream the sensor can't continue. QT recovers a sensor
+// whose response was lost.
+func (s *Server) abort(stop chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-stop:
+		// StopStream is already stopping it
+		return
+	default:
+	}
+	s.streaming = false
+	s.sensor.QMCommand("")
+}
+
+// broadcastError sends a scan error to the JSON clients.
+func (s *Server) broadcastError(err error) {
+	msg, _ := json.Marshal(map[string]string{"error": err.Error()})
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	for c := range s.clients {
+		if c.binary {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+		}
+	}
+}
+
+// broadcast sends a scan to every client, dropping it for clients that
+// have not consumed the previous ones yet.
+func (s *Server) broadcast(scan recording.Scan) {
+	var text, bin []byte
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	for c := range s.clients {
+		var msg []byte
+		if c.binary {
+			if bin == nil {
+				bin = EncodeBinary(scan)
+			}
+			msg = bin
+		} else {
+			if text == nil {
+				text, _ = json.Marshal(scan)
+			}
+			msg = text
+		}
+		select {
+		case c.send <- msg:
+		default:
+		}
+	}
+}
+
+// EncodeBinary encodes a scan in the compact binary WebSocket format.
+// Distances larger than 65535 are clamped.
+func EncodeBinary(scan recording.Scan) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(scan.Timestamp))
+	binary.Write(&b, binary.LittleEndian, float32(scan.StartAngle))
+	binary.Write(&b, binary.LittleEndian, float32(scan.Step))
+	binary.Write(&b, binary.LittleEndian, uint16(len(scan.Distances)))
+	for _, d := range scan.Distances {
+		binary.Write(&b, binary.LittleEndian, uint16(math.Min(float64(d), math.MaxUint16)))
+	}
+	return b.Bytes()
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "binary" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Unknown format %q", format))
+		return
+	}
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := &client{binary: format == "binary", send: make(chan []byte, 4), pong: make(chan []byte, 1)}
+	s.clientsMutex.Lock()
+	s.clients[c] = struct{}{}
+	s.clientsMutex.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			op, payload, err := conn.readFrame()
+			if err != nil || op == opClose {
+				return
+			}
+			if op == opPing {
+				select {
+				case c.pong <- payload:
+				default:
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		s.clientsMutex.Lock()
+		delete(s.clients, c)
+		s.clientsMutex.Unlock()
+		conn.writeFrame(opClose, nil)
+		conn.Close()
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case payload := <-c.pong:
+			if conn.writeFrame(opPong, payload) != nil {
+				return
+			}
+		case msg := <-c.send:
+			op := opText
+			if c.binary {
+				op = opBinary
+			}
+			if conn.writeFrame(op, msg) != nil {
+				return
+			}
+		}
+	}
+}
+
+// postJSON rejects control requests that are not JSON POSTs. Browsers send
+// form and text/plain POSTs to other origins without a preflight, so
+// requiring JSON keeps other web pages from controlling the sensor.
+func postJSON(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Use POST"))
+		return false
+	}
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("Use Content-Type: application/json"))
+		return false
+	}
+	return true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !postJSON(w, r) {
+		return false
+	}
+	if r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func writeCommandError(w http.ResponseWriter, err error) {
+	if errors.Is(err, lidar.ErrStreaming) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, err)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lidar "github.com/Dolphindalt/GoHokuyoLidar"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+)
+
+// fakeSensor records the commands it receives and produces a fixed scan
+// while streaming.
+type fakeSensor struct {
+	mutex     sync.Mutex
+	commands  []string
+	streaming bool
+	err       error // returned by GetDistance
+	reads     int
+	block     chan struct{} // GetDistance waits for it to be closed
+}
+
+func (f *fakeSensor) record(c string) {
+	f.mutex.Lock()
+	f.commands = append(f.commands, c)
+	f.mutex.Unlock()
+}
+
+func (f *fakeSensor) VVCommand(string) ([]string, error) {
+	f.record("VV")
+	return []string{"VEND:Hokuyo Automatic Co.,Ltd.;", "SERI:H0000000;"}, nil
+}
+func (f *fakeSensor) PPCommand(string) ([]string, error) {
+	f.record("PP")
+	return []string{"AMIN:44;", "AMAX:725;"}, nil
+}
+func (f *fakeSensor) IICommand(string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.streaming {
+		return nil, lidar.ErrStreaming
+	}
+	f.commands = append(f.commands, "II")
+	return []string{"LASR:OFF;"}, nil
+}
+func (f *fakeSensor) BMCommand(string) error { f.record("BM"); return nil }
+func (f *fakeSensor) QMCommand(string) error {
+	f.record("QT")
+	f.mutex.Lock()
+	f.streaming = false
+	f.mutex.Unlock()
+	return nil
+}
+func (f *fakeSensor) CRCommand(c string) error            { f.record("CR" + c); return nil }
+func (f *fakeSensor) HSCommand(high bool, _ string) error { f.record("HS"); return nil }
+func (f *fakeSensor) MDMSCmd(bool, int, int, int, int, int, string) error {
+	f.record("MD")
+	f.mutex.Lock()
+	f.streaming = true
+	f.mutex.Unlock()
+	return nil
+}
+func (f *fakeSensor) GetDistance() ([]int, int, error) {
+	time.Sleep(time.Millisecond)
+	f.mutex.Lock()
+	block := f.block
+	f.mutex.Unlock()
+	if block != nil {
+		<-block
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.reads++
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	return []int{1000, 2000, 70000}, 42, nil
+}
+func (f *fakeSensor) StartAngle() float64  { return -math.Pi / 2 }
+func (f *fakeSensor) AngularStep() float64 { return math.Pi / 2 }
+
+func post(t *testing.T, url, body string) *http.Response {
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestInfoAndControl(t *testing.T) {
+	sensor := &fakeSensor{}
+	ts := httptest.NewServer(New(sensor).Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/api/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info map[string]string
+	json.NewDecoder(res.Body).Decode(&info)
+	res.Body.Close()
+	if info["SERI"] != "H0000000;" {
+		t.Fatalf("Expected the serial number, got %v\n", info)
+	}
+
+	for _, c := range []struct{ path, body string }{
+		{"/api/laser", `{"on": true}`},
+		{"/api/motor", `{"speed": 5}`},
+		{"/api/sensitivity", `{"high": true}`},
+		{"/api/laser", `{"on": false}`},
+	} {
+		res := post(t, ts.URL+c.path, c.body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d\n", c.path, res.StatusCode)
+		}
+	}
+	want := "VV BM CR05 HS QT"
+	if got := strings.Join(sensor.commands, " "); got != want {
+		t.Fatalf("Expected commands %q, got %q\n", want, got)
+	}
+
+	res = post(t, ts.URL+"/api/motor", `{"speed": 11}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an invalid speed, got %d\n", res.StatusCode)
+	}
+}
+
+// handshake sends a client handshake with extra header lines to the test
+// server.
+func handshake(t *testing.T, ts *httptest.Server, query, header string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET /ws"+query+" HTTP/1.1\r\nHost: test\r\n"+header+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, res
+}
+
+// dial performs a client handshake against the test server.
+func dial(t *testing.T, ts *httptest.Server, query string) (net.Conn, *bufio.Reader) {
+	conn, r, res := handshake(t, ts, query, "")
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d\n", res.StatusCode)
+	}
+	// the example of RFC 6455
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key %q\n", got)
+	}
+	return conn, r
+}
+
+func readMessage(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func TestWebSocketStream(t *testing.T) {
+	s := New(&fakeSensor{})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	jsonConn, jsonReader := dial(t, ts, "")
+	defer jsonConn.Close()
+	binConn, binReader := dial(t, ts, "?format=binary")
+	defer binConn.Close()
+
+	res := post(t, ts.URL+"/api/stream/start", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the stream to start, got %d\n", res.StatusCode)
+	}
+	res, _ = http.Get(ts.URL + "/api/state")
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected the state query to be rejected while streaming, got %d\n", res.StatusCode)
+	}
+	res = post(t, ts.URL+"/api/laser", `{"on": true}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected control commands to be rejected while streaming, got %d\n", res.StatusCode)
+	}
+
+	op, payload := readMessage(t, jsonReader)
+	var scan recording.Scan
+	if err := json.Unmarshal(payload, &scan); op != opText || err != nil {
+		t.Fatalf("Expected a JSON text frame, got opcode %d: %v\n", op, err)
+	}
+	if scan.Timestamp != 42 || len(scan.Distances) != 3 || scan.Step != math.Pi/2 {
+		t.Fatalf("Unexpected scan %+v\n", scan)
+	}
+
+	op, payload = readMessage(t, binReader)
+	if op != opBinary || len(payload) != 14+3*2 {
+		t.Fatalf("Expected a binary frame of 20 bytes, got opcode %d with %d bytes\n", op, len(payload))
+	}
+	if ts := binary.LittleEndian.Uint32(payload); ts != 42 {
+		t.Fatalf("Expected timestamp 42, got %d\n", ts)
+	}
+	if d := binary.LittleEndian.Uint16(payload[18:]); d != math.MaxUint16 {
+		t.Fatalf("Expected the long distance to be clamped, got %d\n", d)
+	}
+
+	if err := s.StopStream(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func streamStatus(t *testing.T, ts *httptest.Server) (status struct {
+	Streaming bool   `json:"streaming"`
+	Error     string `json:"error"`
+}) {
+	res, err := http.Get(ts.URL + "/api/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	json.NewDecoder(res.Body).Decode(&status)
+	return status
+}
+
+func TestStreamErrors(t *testing.T) {
+	sensor := &fakeSensor{err: lidar.ErrChecksum}
+	s := New(sensor)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	conn, reader := dial(t, ts, "")
+	defer conn.Close()
+
+	if err := s.StartStream(DefaultStreamConfig()); err != nil {
+		t.Fatal(err)
+	}
+	op, payload := readMessage(t, reader)
+	var msg map[string]string
+	if err := json.Unmarshal(payload, &msg); op != opText || err != nil || msg["error"] != lidar.ErrChecksum.Error() {
+		t.Fatalf("Expected the scan error, got opcode %d: %s\n", op, payload)
+	}
+	time.Sleep(100 * time.Millisecond)
+	sensor.mutex.Lock()
+	reads := sensor.reads
+	sensor.mutex.Unlock()
+	if reads > 10 {
+		t.Fatalf("Expected the stream to back off, got %d reads in 100ms\n", reads)
+	}
+	if status := streamStatus(t, ts); !status.Streaming || status.Error == "" {
+		t.Fatalf("Expected a transient error to keep streaming, got %+v\n", status)
+	}
+
+	sensor.mutex.Lock()
+	sensor.err = &lidar.TransitionError{Command: "GetDistance", State: lidar.StateError}
+	sensor.mutex.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for streamStatus(t, ts).Streaming {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the stream to stop on an invalid state")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := streamStatus(t, ts); status.Error == "" {
+		t.Fatalf("Expected the error on the status endpoint, got %+v\n", status)
+	}
+	if got := strings.Join(sensor.commands, " "); got != "MD QT" {
+		t.Fatalf("Expected the stream to be stopped with QT, got %q\n", got)
+	}
+	if err := s.StartStream(DefaultStreamConfig()); err != nil {
+		t.Fatalf("Expected to restart the stream, got %v\n", err)
+	}
+	sensor.mutex.Lock()
+	sensor.err = nil
+	sensor.mutex.Unlock()
+	if err := s.StopStream(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopStreamTimeout(t *testing.T) {
+	defer func(d time.Duration) { stopTimeout = d }(stopTimeout)
+	stopTimeout = 50 * time.Millisecond
+	block := make(chan struct{})
+	sensor := &fakeSensor{block: block}
+	s := New(sensor)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	if err := s.StartStream(DefaultStreamConfig()); err != nil {
+		t.Fatal(err)
+	}
+	// let the stream block in GetDistance
+	time.Sleep(20 * time.Millisecond)
+	if err := s.StopStream(); !errors.Is(err, lidar.ErrTimeout) {
+		t.Fatalf("Expected a timeout while the scan is read, got %v\n", err)
+	}
+	if !streamStatus(t, ts).Streaming {
+		t.Fatal("Expected the stream to keep running after the timeout")
+	}
+	close(block)
+	if err := s.StopStream(); err != nil {
+		t.Fatalf("Expected the stream to stop, got %v\n", err)
+	}
+	if got := strings.Join(sensor.commands, " "); got != "MD QT" {
+		t.Fatalf("Expected the stream to be stopped with QT, got %q\n", got)
+	}
+}
+
+func TestCrossOrigin(t *testing.T) {
+	sensor := &fakeSensor{}
+	ts := httptest.NewServer(New(sensor).Handler())
+	defer ts.Close()
+
+	for _, path := range []string{"/api/laser", "/api/stream/stop"} {
+		res, err := http.Post(ts.URL+path, "text/plain", strings.NewReader(`{"on": true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("%s: expected 415 for a text/plain POST, got %d\n", path, res.StatusCode)
+		}
+	}
+	if len(sensor.commands) != 0 {
+		t.Fatalf("Expected no commands, got %v\n", sensor.commands)
+	}
+
+	conn, _, res := handshake(t, ts, "", "Origin: http://example.com\r\n")
+	conn.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a cross-origin upgrade, got %d\n", res.StatusCode)
+	}
+	conn, _, res = handshake(t, ts, "", "Origin: http://test\r\n")
+	conn.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101 for a same-origin upgrade, got %d\n", res.StatusCode)
+	}
+}
+
+func TestViewer(t *testing.T) {
+	ts := httptest.NewServer(New(&fakeSensor{}).Handler())
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	page, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(page), "<canvas") {
+		t.Fatal("Expected the canvas viewer")
+	}
+}
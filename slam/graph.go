@@ -0,0 +1,230 @@
+package slam
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Edge is a relative pose constraint between two nodes of the graph.
+type Edge struct {
+	From        int        // index of the first pose
+	To          int        // index of the second pose
+	Measurement geom.Pose  // pose of To in the frame of From
+	Information mgl64.Mat3 // inverse covariance of the measurement
+	Loop        bool       // whether the edge closes a loop
+}
+
+// Graph is a pose graph. The first pose is held fixed during optimization.
+type Graph struct {
+	Poses []geom.Pose
+	Edges []Edge
+}
+
+// Error returns the weighted squared error of all edges.
+func (g *Graph) Error() float64 {
+	sum := 0.0
+	for _, e := range g.Edges {
+		r := residual(g.Poses[e.From], g.Poses[e.To], e.Measurement)
+		sum += r.Dot(e.Information.Mul3x1(r))
+	}
+	return sum
+}
+
+// Optimize runs Gauss-Newton on the graph, solving each step with a
+// preconditioned conjugate gradient on the sparse block system, until the
+// update becomes negligible. It returns the final error.
+func (g *Graph) Optimize(iterations int) (float64, error) {
+	n := len(g.Poses)
+	if n < 2 {
+		return 0, nil
+	}
+	for it := 0; it < iterations; it++ {
+		h := newBlockMatrix(n)
+		b := make([]float64, 3*n)
+		for _, e := range g.Edges {
+			xi, xj := g.Poses[e.From], g.Poses[e.To]
+			r := residual(xi, xj, e.Measurement)
+			a, bj := jacobians(xi, xj, e.Measurement)
+			at := a.Transpose().Mul3(e.Information)
+			bt := bj.Transpose().Mul3(e.Information)
+			h.add(e.From, e.From, at.Mul3(a))
+			h.add(e.From, e.To, at.Mul3(bj))
+			h.add(e.To, e.From, bt.Mul3(a))
+			h.add(e.To, e.To, bt.Mul3(bj))
+			ga := at.Mul3x1(r)
+			gb := bt.Mul3x1(r)
+			for k := 0; k < 3; k++ {
+				b[3*e.From+k] -= ga[k]
+				b[3*e.To+k] -= gb[k]
+			}
+		}
+		h.fix(0)
+		for k := 0; k < 3; k++ {
+			b[k] = 0
+		}
+
+		dx, err := h.solve(b, 10*n, 1e-12)
+		if err != nil {
+			return g.Error(), err
+		}
+		largest := 0.0
+		for i := range g.Poses {
+			g.Poses[i].X += dx[3*i]
+			g.Poses[i].Y += dx[3*i+1]
+			g.Poses[i].Theta = geom.NormalizeAngle(g.Poses[i].Theta + dx[3*i+2])
+			largest = math.Max(largest, math.Max(math.Abs(dx[3*i]), math.Abs(dx[3*i+1])))
+			largest = math.Max(largest, 1000.0*math.Abs(dx[3*i+2]))
+		}
+		if largest < 1e-6 {
+			break
+		}
+	}
+	return g.Error(), nil
+}
+
+// residual is the difference between the predicted and measured relative pose.
+func residual(xi, xj, z geom.Pose) mgl64.Vec3 {
+	p := xi.Between(xj)
+	d := z.Inverse().Transform(mgl64.Vec2{p.X, p.Y})
+	return mgl64.Vec3{d[0], d[1], geom.NormalizeAngle(p.Theta - z.Theta)}
+}
+
+// jacobians returns the derivatives of the residual with respect to xi and
+// xj. mgl64 matrices are column major, each column is one pose variable.
+func jacobians(xi, xj, z geom.Pose) (mgl64.Mat3, mgl64.Mat3) {
+	si, ci := math.Sincos(xi.Theta)
+	sz, cz := math.Sincos(z.Theta)
+	s, c := math.Sincos(xi.Theta + z.Theta)
+	dx, dy := xj.X-xi.X, xj.Y-xi.Y
+
+	// derivative of Rz^T Ri^T (tj - ti) with respect to theta_i
+	ddx := -si*dx + ci*dy
+	ddy := -ci*dx - si*dy
+	ex := cz*ddx + sz*ddy
+	ey := -sz*ddx + cz*ddy
+
+	a := mgl64.Mat3{
+		-c, s, 0,
+		-s, -c, 0,
+		ex, ey, -1,
+	}
+	b := mgl64.Mat3{
+		c, -s, 0,
+		s, c, 0,
+		0, 0, 1,
+	}
+	return a, b
+}
+
+type block struct {
+	col int
+	m   mgl64.Mat3
+}
+
+// blockMatrix is a sparse symmetric matrix of 3x3 blocks.
+type blockMatrix struct {
+	rows []map[int]mgl64.Mat3
+}
+
+func newBlockMatrix(n int) *blockMatrix {
+	rows := make([]map[int]mgl64.Mat3, n)
+	for i := range rows {
+		rows[i] = map[int]mgl64.Mat3{}
+	}
+	return &blockMatrix{rows: rows}
+}
+
+func (h *blockMatrix) add(i, j int, m mgl64.Mat3) {
+	h.rows[i][j] = h.rows[i][j].Add(m)
+}
+
+// fix removes the variables of block i from the system so that the
+// solution leaves them unchanged.
+func (h *blockMatrix) fix(i int) {
+	for j := range h.rows[i] {
+		delete(h.rows[i], j)
+		delete(h.rows[j], i)
+	}
+	h.rows[i][i] = mgl64.Ident3()
+}
+
+// sorted returns the blocks of every row ordered by column so that the
+// products are computed in a deterministic order.
+func (h *blockMatrix) sorted() [][]block {
+	out := make([][]block, len(h.rows))
+	for i, row := range h.rows {
+		for j, m := range row {
+			out[i] = append(out[i], block{j, m})
+		}
+		sort.Slice(out[i], func(a, b int) bool { return out[i][a].col < out[i][b].col })
+	}
+	return out
+}
+
+// solve solves H x = b with the conjugate gradient method using the
+// inverse diagonal blocks as preconditioner.
+func (h *blockMatrix) solve(b []float64, maxIter int, tolerance float64) ([]float64, error) {
+	rows := h.sorted()
+	n := len(rows)
+	precond := make([]mgl64.Mat3, n)
+	for i := range rows {
+		d := h.rows[i][i]
+		if math.Abs(d.Det()) < 1e-12 {
+			return nil, errors.New("Pose graph is not connected")
+		}
+		precond[i] = d.Inv()
+	}
+	mul := func(v []float64) []float64 {
+		out := make([]float64, len(v))
+		for i, row := range rows {
+			var s mgl64.Vec3
+			for _, blk := range row {
+				s = s.Add(blk.m.Mul3x1(mgl64.Vec3{v[3*blk.col], v[3*blk.col+1], v[3*blk.col+2]}))
+			}
+			out[3*i], out[3*i+1], out[3*i+2] = s[0], s[1], s[2]
+		}
+		return out
+	}
+	apply := func(v []float64) []float64 {
+		out := make([]float64, len(v))
+		for i := range precond {
+			s := precond[i].Mul3x1(mgl64.Vec3{v[3*i], v[3*i+1], v[3*i+2]})
+			out[3*i], out[3*i+1], out[3*i+2] = s[0], s[1], s[2]
+		}
+		return out
+	}
+	dot := func(a, c []float64) float64 {
+		s := 0.0
+		for i := range a {
+			s += a[i] * c[i]
+		}
+		return s
+	}
+
+	x := make([]float64, len(b))
+	r := append([]float64{}, b...)
+	z := apply(r)
+	p := append([]float64{}, z...)
+	rz := dot(r, z)
+	norm := math.Max(dot(b, b), 1e-30)
+	for it := 0; it < maxIter && dot(r, r) > tolerance*norm; it++ {
+		hp := mul(p)
+		alpha := rz / dot(p, hp)
+		for i := range x {
+			x[i] += alpha * p[i]
+			r[i] -= alpha * hp[i]
+		}
+		z = apply(r)
+		next := dot(r, z)
+		beta := next / rz
+		rz = next
+		for i := range p {
+			p[i] = z[i] + beta*p[i]
+		}
+	}
+	return x, nil
+}
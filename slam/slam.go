@@ -0,0 +1,255 @@
+// Package slam builds maps with graph based SLAM from a stream of scans.
+//
+// Consecutive scans are aligned with ICP to track the sensor. Whenever it
+// has moved far enough a keyframe is created and aligned against a submap
+// of the latest keyframes with the correlative matcher. Every new keyframe
+// is also matched against older keyframes nearby to detect loop closures,
+// after which the pose graph is optimized.
+package slam
+
+import (
+	"io"
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/occgrid"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/Dolphindalt/GoHokuyoLidar/scanmatch"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Config holds the parameters of the SLAM system.
+type Config struct {
+	// KeyframeDistance and KeyframeAngle are the motion in millimeters and
+	// radians after which a new keyframe is created.
+	KeyframeDistance float64
+	KeyframeAngle    float64
+	// SubmapSize is the number of latest keyframes new keyframes are
+	// matched against.
+	SubmapSize int
+	// LoopSearchRadius is the distance in millimeters within which older
+	// keyframes are considered for loop closure.
+	LoopSearchRadius float64
+	// LoopMinScore is the smallest match score accepted as a loop closure.
+	LoopMinScore float64
+	// LoopMinSeparation is the number of keyframes that must lie between
+	// two keyframes for them to be checked for a loop closure.
+	LoopMinSeparation int
+	// OptimizeIterations bounds the Gauss-Newton iterations per optimization.
+	OptimizeIterations int
+
+	ICP         scanmatch.ICPConfig
+	Submap      scanmatch.CorrelativeConfig
+	LoopClosure scanmatch.CorrelativeConfig
+	Map         occgrid.Config
+}
+
+// DefaultConfig returns parameters suited for the URG-04LX.
+func DefaultConfig() Config {
+	loop := scanmatch.DefaultCorrelativeConfig()
+	loop.WindowXY = 1000.0
+	loop.WindowTheta = 0.5
+	loop.Resolution = 40.0
+	return Config{
+		KeyframeDistance:   300.0,
+		KeyframeAngle:      0.3,
+		SubmapSize:         5,
+		LoopSearchRadius:   1500.0,
+		LoopMinScore:       0.6,
+		LoopMinSeparation:  10,
+		OptimizeIterations: 20,
+		ICP:                scanmatch.DefaultICPConfig(),
+		Submap:             scanmatch.DefaultCorrelativeConfig(),
+		LoopClosure:        loop,
+		Map:                occgrid.DefaultConfig(),
+	}
+}
+
+// Keyframe is a scan retained in the pose graph.
+type Keyframe struct {
+	Pose   geom.Pose
+	Scan   recording.Scan
+	Points []mgl64.Vec2 // valid points in the sensor frame
+
+	matcher *scanmatch.CorrelativeMatcher
+}
+
+// SLAM incrementally builds a pose graph from scans.
+type SLAM struct {
+	config    Config
+	keyframes []*Keyframe
+	graph     Graph
+	pose      geom.Pose
+	velocity  geom.Pose
+	previous  []mgl64.Vec2
+}
+
+// New creates an empty SLAM system starting at the origin.
+func New(config Config) *SLAM {
+	return &SLAM{config: config}
+}
+
+// AddScan processes the next scan of the stream and returns the current pose
+// estimate of the sensor.
+func (s *SLAM) AddScan(scan recording.Scan) (geom.Pose, error) {
+	points := scan.Points()
+	if len(s.keyframes) == 0 {
+		s.addKeyframe(s.pose, scan, points)
+		s.previous = points
+		return s.pose, nil
+	}
+
+	res, err := scanmatch.ICP(s.previous, points, s.velocity, s.config.ICP)
+	if err == nil {
+		s.velocity = res.Pose
+	}
+	s.pose = s.pose.Compose(s.velocity)
+	s.previous = points
+
+	last := s.keyframes[len(s.keyframes)-1]
+	motion := last.Pose.Between(s.pose)
+	if math.Hypot(motion.X, motion.Y) < s.config.KeyframeDistance && math.Abs(motion.Theta) < s.config.KeyframeAngle {
+		return s.pose, nil
+	}
+
+	matched, err := s.matchSubmap(points)
+	if err != nil {
+		return s.pose, err
+	}
+	s.pose = matched.Pose
+	s.addKeyframe(s.pose, scan, points)
+	s.addEdge(len(s.keyframes)-2, len(s.keyframes)-1, last.Pose.Between(s.pose), matched.Covariance, false)
+
+	if s.detectLoops() {
+		if _, err := s.Optimize(); err != nil {
+			return s.pose, err
+		}
+	}
+	return s.pose, nil
+}
+
+// matchSubmap aligns the points with the latest keyframes in world frame.
+func (s *SLAM) matchSubmap(points []mgl64.Vec2) (scanmatch.CorrelativeResult, error) {
+	first := len(s.keyframes) - s.config.SubmapSize
+	if first < 0 {
+		first = 0
+	}
+	submap := []mgl64.Vec2{}
+	for _, kf := range s.keyframes[first:] {
+		submap = append(submap, kf.Pose.TransformAll(kf.Points)...)
+	}
+	m := scanmatch.NewCorrelativeMatcher(submap, s.config.Submap)
+	return m.Match(points, s.pose)
+}
+
+// detectLoops matches the newest keyframe against older keyframes nearby
+// and adds an edge for every accepted match.
+func (s *SLAM) detectLoops() bool {
+	newest := len(s.keyframes) - 1
+	kf := s.keyframes[newest]
+	found := false
+	for i := 0; i <= newest-s.config.LoopMinSeparation; i++ {
+		old := s.keyframes[i]
+		if kf.Pose.Vec().Sub(old.Pose.Vec()).Len() > s.config.LoopSearchRadius {
+			continue
+		}
+		if old.matcher == nil {
+			old.matcher = scanmatch.NewCorrelativeMatcher(old.Points, s.config.LoopClosure)
+		}
+		res, err := old.matcher.Match(kf.Points, old.Pose.Between(kf.Pose))
+		if err != nil || res.Score < s.config.LoopMinScore {
+			continue
+		}
+		s.addEdge(i, newest, res.Pose, res.Covariance, true)
+		found = true
+	}
+	return found
+}
+
+func (s *SLAM) addKeyframe(pose geom.Pose, scan recording.Scan, points []mgl64.Vec2) {
+	s.keyframes = append(s.keyframes, &Keyframe{Pose: pose, Scan: scan, Points: geom.Pose{}.TransformAll(points)})
+	s.graph.Poses = append(s.graph.Poses, pose)
+}
+
+func (s *SLAM) addEdge(from, to int, measurement geom.Pose, covariance mgl64.Mat3, loop bool) {
+	s.graph.Edges = append(s.graph.Edges, Edge{
+		From:        from,
+		To:          to,
+		Measurement: measurement,
+		Information: covariance.Inv(),
+		Loop:        loop,
+	})
+}
+
+// Optimize optimizes the pose graph and moves the keyframes and the current
+// pose estimate accordingly. It returns the remaining graph error.
+func (s *SLAM) Optimize() (float64, error) {
+	if len(s.keyframes) == 0 {
+		return 0, nil
+	}
+	newest := s.keyframes[len(s.keyframes)-1].Pose
+	offset := newest.Between(s.pose)
+	residual, err := s.graph.Optimize(s.config.OptimizeIterations)
+	if err != nil {
+		return residual, err
+	}
+	for i, kf := range s.keyframes {
+		kf.Pose = s.graph.Poses[i]
+	}
+	s.pose = s.keyframes[len(s.keyframes)-1].Pose.Compose(offset)
+	return residual, nil
+}
+
+// Pose returns the current pose estimate.
+func (s *SLAM) Pose() geom.Pose {
+	return s.pose
+}
+
+// Keyframes returns the keyframes of the graph.
+func (s *SLAM) Keyframes() []*Keyframe {
+	return s.keyframes
+}
+
+// Graph returns the pose graph.
+func (s *SLAM) Graph() *Graph {
+	return &s.graph
+}
+
+// Trajectory returns the poses of the keyframes.
+func (s *SLAM) Trajectory() []geom.Pose {
+	poses := make([]geom.Pose, len(s.keyframes))
+	for i, kf := range s.keyframes {
+		poses[i] = kf.Pose
+	}
+	return poses
+}
+
+// Map renders the keyframes into an occupancy grid.
+func (s *SLAM) Map() *occgrid.Grid {
+	g := occgrid.NewGrid(s.config.Map)
+	for _, kf := range s.keyframes {
+		g.Integrate(kf.Pose, kf.Scan.Distances, kf.Scan.StartAngle, kf.Scan.Step)
+	}
+	return g
+}
+
+// Run processes a whole recording offline, optimizes the final graph and
+// returns the SLAM system holding the trajectory and map.
+func Run(r io.Reader, config Config) (*SLAM, error) {
+	s := New(config)
+	reader := recording.NewReader(r)
+	for {
+		scan, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s, err
+		}
+		if _, err := s.AddScan(scan); err != nil {
+			return s, err
+		}
+	}
+	_, err := s.Optimize()
+	return s, err
+}
@@ -0,0 +1,138 @@
+package slam
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/geom"
+	"github.com/Dolphindalt/GoHokuyoLidar/recording"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// simulate returns a scan of a 6m by 4m room with a pillar, seen from pose.
+func simulate(pose geom.Pose) recording.Scan {
+	s := recording.Scan{StartAngle: -2.09, Step: 2 * math.Pi / 1024}
+	for i := 0; i < 682; i++ {
+		theta := pose.Theta + s.Angle(i)
+		dir := mgl64.Vec2{math.Cos(theta), math.Sin(theta)}
+		r := math.Inf(1)
+		if dir[0] > 0 {
+			r = math.Min(r, (3000-pose.X)/dir[0])
+		} else if dir[0] < 0 {
+			r = math.Min(r, (-3000-pose.X)/dir[0])
+		}
+		if dir[1] > 0 {
+			r = math.Min(r, (2000-pose.Y)/dir[1])
+		} else if dir[1] < 0 {
+			r = math.Min(r, (-2000-pose.Y)/dir[1])
+		}
+		// pillar of radius 200 at (1000, 800)
+		oc := pose.Vec().Sub(mgl64.Vec2{1000, 800})
+		b := oc.Dot(dir)
+		if disc := b*b - oc.Dot(oc) + 200*200; disc > 0 && -b-math.Sqrt(disc) > 0 {
+			r = math.Min(r, -b-math.Sqrt(disc))
+		}
+		d := int(r)
+		if d > 5600 {
+			d = 0
+		}
+		s.Distances = append(s.Distances, d)
+	}
+	return s
+}
+
+func TestGraphOptimize(t *testing.T) {
+	info := mgl64.Ident3()
+	g := Graph{Poses: []geom.Pose{{}, {X: 1100, Y: 50, Theta: 1.6}, {X: 950, Y: 1050, Theta: 3.1}, {X: -80, Y: 1000, Theta: -1.5}}}
+	z := geom.Pose{X: 1000, Theta: math.Pi / 2}
+	for i := 0; i < 3; i++ {
+		g.Edges = append(g.Edges, Edge{From: i, To: i + 1, Measurement: z, Information: info})
+	}
+	g.Edges = append(g.Edges, Edge{From: 3, To: 0, Measurement: z, Information: info, Loop: true})
+
+	before := g.Error()
+	after, err := g.Optimize(20)
+	if err != nil {
+		t.Fatalf("Optimize failed: %v\n", err)
+	}
+	if after > before || after > 1e-6 {
+		t.Fatalf("Expected the error to vanish, went from %v to %v\n", before, after)
+	}
+	if d := g.Poses[2].Vec().Sub(mgl64.Vec2{1000, 1000}).Len(); d > 1e-3 {
+		t.Fatalf("Expected pose 2 at (1000, 1000), got %v\n", g.Poses[2])
+	}
+}
+
+func TestRun(t *testing.T) {
+	var b bytes.Buffer
+	w := recording.NewWriter(&b)
+	truth := []geom.Pose{}
+	for i := 0; i < 30; i++ {
+		p := geom.Pose{X: -1500 + float64(i)*50, Y: -500 + float64(i)*10, Theta: float64(i) * 0.01}
+		truth = append(truth, p)
+		w.Write(simulate(p))
+	}
+
+	s, err := Run(&b, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Run failed: %v\n", err)
+	}
+	if len(s.Keyframes()) < 3 {
+		t.Fatalf("Expected several keyframes, got %v\n", len(s.Keyframes()))
+	}
+	expected := truth[0].Between(truth[len(truth)-1])
+	got := s.Pose()
+	if math.Hypot(got.X-expected.X, got.Y-expected.Y) > 60 || math.Abs(got.Theta-expected.Theta) > 0.03 {
+		t.Fatalf("Expected final pose %v, got %v\n", expected, got)
+	}
+	if m := s.Map(); m.Width == 0 {
+		t.Fatalf("Expected a map\n")
+	}
+}
+
+func TestLoopClosure(t *testing.T) {
+	// circle the room once and continue past the start
+	s := New(DefaultConfig())
+	n := 72
+	for i := 0; i <= n+6; i++ {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		if _, err := s.AddScan(simulate(geom.Pose{X: -500 + 800*math.Sin(a), Y: -300 - 800*math.Cos(a), Theta: a})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := s.Graph()
+	loops := 0
+	for _, e := range g.Edges {
+		if e.Loop && e.From < 3 {
+			loops++
+		}
+	}
+	if loops == 0 {
+		t.Fatalf("Expected a loop closure back to the start among %d edges\n", len(g.Edges))
+	}
+
+	// corrupt an odometry edge halfway and dead reckon the keyframes
+	estimate := append([]geom.Pose{}, g.Poses...)
+	last := len(g.Poses) - 1
+	for i, e := range g.Edges {
+		if !e.Loop && e.To == last/2 {
+			g.Edges[i].Measurement = e.Measurement.Compose(geom.Pose{X: 100, Theta: 0.1})
+		}
+	}
+	for _, e := range g.Edges {
+		if !e.Loop {
+			g.Poses[e.To] = g.Poses[e.From].Compose(e.Measurement)
+		}
+	}
+	drift := func() float64 {
+		return g.Poses[last].Vec().Sub(estimate[last].Vec()).Len()
+	}
+	before := drift()
+	if _, err := g.Optimize(DefaultConfig().OptimizeIterations); err != nil {
+		t.Fatal(err)
+	}
+	if after := drift(); after > before/3 {
+		t.Fatalf("Expected the loop closure to remove the drift, went from %.0fmm to %.0fmm\n", before, after)
+	}
+}
@@ -0,0 +1,147 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is the mode of the driver and the sensor.
+type State int
+
+// The states of the driver. A lidar starts disconnected, is idle after
+// Connect, RSCommand and QMCommand, and enters StateError when the
+// response of a command could not be read, which leaves the serial stream
+// out of step. QMCommand or RSCommand recover from StateError.
+const (
+	StateDisconnected State = iota
+	StateIdle
+	StateLaserOn
+	StateStreaming  // MD/MS running
+	StateTimeAdjust // TM adjust mode, the laser is off
+	StateError
+)
+
+var stateNames = []string{"disconnected", "idle", "laser-on", "streaming", "time-adjust", "error"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// ErrInvalidState is matched by every TransitionError. ErrNoScan is returned
+// by GetDistance and GetDistanceAndIntensity when no scan was requested.
+var (
+	ErrInvalidState = errors.New("Command is not valid in the current state")
+	ErrNoScan       = errors.New("No scan requested, send MDMSCmd or GDGSCommand first")
+)
+
+// TransitionError is returned for a command sent in a state that does not
+// accept it. It matches ErrInvalidState, and ErrNotConnected or
+// ErrStreaming when those states caused it.
+type TransitionError struct {
+	Command string
+	State   State
+}
+
+func (e *TransitionError) Error() string {
+	switch e.State {
+	case StateDisconnected:
+		return fmt.Sprintf("%v: %v", e.Command, ErrNotConnected)
+	case StateStreaming:
+		return fmt.Sprintf("%v: %v", e.Command, ErrStreaming)
+	}
+	return fmt.Sprintf("%v is not valid in state %v", e.Command, e.State)
+}
+
+// Is matches ErrInvalidState and the sentinel of the state.
+func (e *TransitionError) Is(target error) bool {
+	switch target {
+	case ErrInvalidState:
+		return true
+	case ErrNotConnected:
+		return e.State == StateDisconnected
+	case ErrStreaming:
+		return e.State == StateStreaming
+	}
+	return false
+}
+
+// StateChange is called after a transition from one state to another.
+type StateChange func(from, to State)
+
+// OnStateChange registers a callback for every state transition. The
+// callbacks run after the command causing the transition returned its
+// lock, so they may use the lidar.
+func (h *HokuyoLidar) OnStateChange(fn StateChange) {
+	h.lock()
+	defer h.unlock()
+	h.callbacks = append(h.callbacks, fn)
+}
+
+// State returns the current state.
+func (h *HokuyoLidar) State() State {
+	h.lock()
+	defer h.unlock()
+	return h.state
+}
+
+// Connected reports whether the serial port is open.
+func (h *HokuyoLidar) Connected() bool {
+	return h.State() != StateDisconnected
+}
+
+// Scanning reports whether an MD/MS stream is running.
+func (h *HokuyoLidar) Scanning() bool {
+	return h.State() == StateStreaming
+}
+
+// LaserOn reports whether the laser is on.
+func (h *HokuyoLidar) LaserOn() bool {
+	s := h.State()
+	return s == StateLaserOn || s == StateStreaming
+}
+
+type transition struct {
+	from, to State
+}
+
+// lock serializes the commands. unlock runs the callbacks of the
+// transitions made while locked.
+func (h *HokuyoLidar) lock() {
+	h.mutex.Lock()
+}
+
+func (h *HokuyoLidar) unlock() {
+	transitions := h.transitions
+	callbacks := h.callbacks
+	h.transitions = nil
+	h.mutex.Unlock()
+	for _, t := range transitions {
+		for _, fn := range callbacks {
+			fn(t.from, t.to)
+		}
+	}
+}
+
+// setState moves to a new state, must be called while locked.
+func (h *HokuyoLidar) setState(to State) {
+	if to == h.state {
+		return
+	}
+	h.logger().Info("state changed", "from", h.state.String(), "to", to.String())
+	h.transitions = append(h.transitions, transition{h.state, to})
+	h.state = to
+}
+
+// require returns a TransitionError unless the lidar is in one of the allowed
+// states.
+func (h *HokuyoLidar) require(command string, allowed ...State) error {
+	for _, s := range allowed {
+		if h.state == s {
+			return nil
+		}
+	}
+	return &TransitionError{command, h.state}
+}
@@ -0,0 +1,58 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInvalidTransitions(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	if err := h.BMCommand(""); !errors.Is(err, ErrNotConnected) || !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected a not connected state error, got %v\n", err)
+	}
+
+	h.state = StateIdle
+	if _, _, err := h.GetDistance(); !errors.Is(err, ErrNoScan) {
+		t.Fatalf("Expected ErrNoScan, got %v\n", err)
+	}
+	if err := h.GDGSCommand(true, AMIN, AMAX, 1, ""); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected GD to need the laser, got %v\n", err)
+	}
+	if _, err := h.TMCommand('1', ""); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected TM 1 to need the adjust mode, got %v\n", err)
+	}
+
+	h.state = StateTimeAdjust
+	var stateErr *TransitionError
+	if err := h.CRCommand("05"); !errors.As(err, &stateErr) || stateErr.State != StateTimeAdjust {
+		t.Fatalf("Expected a state error in time-adjust, got %v\n", err)
+	}
+
+	h.state = StateError
+	if _, err := h.IICommand(""); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected queries to fail in the error state, got %v\n", err)
+	}
+}
+
+func TestStateCallbacks(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	h.state = StateStreaming
+	h.streamFrom = StateIdle
+	h.requestTag = mTag
+	h.scansLeft = 1
+
+	var changes []string
+	h.OnStateChange(func(from, to State) {
+		// callbacks may use the lidar
+		if h.State() != to {
+			t.Errorf("Expected state %v in the callback, got %v\n", to, h.State())
+		}
+		changes = append(changes, from.String()+" -> "+to.String())
+	})
+	h.lock()
+	h.scanRead()
+	h.unlock()
+	if len(changes) != 1 || changes[0] != "streaming -> idle" {
+		t.Fatalf("Expected a single transition to idle, got %v\n", changes)
+	}
+}
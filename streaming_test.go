@@ -0,0 +1,67 @@
+package gohokuyolidar
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestQueriesDuringStream(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	h.state = StateStreaming
+	h.requestTag = mTag
+
+	if _, err := h.IICommand(""); !errors.Is(err, ErrStreaming) {
+		t.Fatalf("Expected ErrStreaming for II, got %v\n", err)
+	}
+	if err := h.CRCommand("05"); !errors.Is(err, ErrStreaming) {
+		t.Fatalf("Expected ErrStreaming for CR, got %v\n", err)
+	}
+	if _, err := h.VVCommand(""); !errors.Is(err, ErrStreaming) {
+		t.Fatalf("Expected ErrStreaming for VV before the first answer, got %v\n", err)
+	}
+	h.version = []string{"SERI:H0000000"}
+	info, err := h.VVCommand("")
+	if err != nil || len(info) != 1 || info[0] != "SERI:H0000000" {
+		t.Fatalf("Expected the last VV answer, got %v, %v\n", info, err)
+	}
+	info[0] = ""
+	if h.version[0] == "" {
+		t.Fatal("Expected a copy of the last VV answer")
+	}
+}
+
+func TestFiniteStream(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	h.state = StateStreaming
+	h.streamFrom = StateLaserOn
+	h.requestTag = mTag
+	h.scansLeft = 2
+	h.scanRead()
+	if !h.Scanning() {
+		t.Fatal("Expected the stream to run until its last scan")
+	}
+	h.scanRead()
+	if h.State() != StateLaserOn {
+		t.Fatalf("Expected to return to laser-on after the last scan, got %v\n", h.State())
+	}
+}
+
+func TestConcurrentAccessors(t *testing.T) {
+	h := NewHokuyoLidar("", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.SetMetrics(NewDriverMetrics())
+				h.Connected()
+				h.Scanning()
+				h.LaserOn()
+				h.BMCommand("")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,123 @@
+package tracking
+
+import "math"
+
+// hungarian solves the assignment problem for a rows by cols cost matrix
+// and returns the column assigned to every row, or -1. Pairs whose cost is
+// infinite are never assigned.
+func hungarian(cost [][]float64, rows, cols int) []int {
+	n := rows
+	if cols > n {
+		n = cols
+	}
+	// pad to a square matrix, forbidden pairs get a cost larger than any
+	// real assignment so they are only chosen when nothing else is left
+	big := 1.0
+	for _, row := range cost {
+		for _, c := range row {
+			if !math.IsInf(c, 1) {
+				big += math.Abs(c)
+			}
+		}
+	}
+	a := make([][]float64, n+1)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= n; j++ {
+			c := big
+			if i <= rows && j <= cols && !math.IsInf(cost[i-1][j-1], 1) {
+				c = cost[i-1][j-1]
+			}
+			a[i][j] = c
+		}
+	}
+
+	// Kuhn-Munkres with potentials, 1-indexed
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := a[i0][j] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, rows)
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	for j := 1; j <= n; j++ {
+		i := p[j]
+		if i >= 1 && i <= rows && j <= cols && !math.IsInf(cost[i-1][j-1], 1) {
+			assignment[i-1] = j - 1
+		}
+	}
+	return assignment
+}
+
+// nearestNeighbor greedily assigns the cheapest remaining pairs first.
+func nearestNeighbor(cost [][]float64, rows, cols int) []int {
+	assignment := make([]int, rows)
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	taken := make([]bool, cols)
+	for {
+		bi, bj, best := -1, -1, math.Inf(1)
+		for i := 0; i < rows; i++ {
+			if assignment[i] >= 0 {
+				continue
+			}
+			for j := 0; j < cols; j++ {
+				if !taken[j] && cost[i][j] < best {
+					bi, bj, best = i, j, cost[i][j]
+				}
+			}
+		}
+		if bi < 0 {
+			return assignment
+		}
+		assignment[bi] = bj
+		taken[bj] = true
+	}
+}
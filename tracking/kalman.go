@@ -0,0 +1,73 @@
+package tracking
+
+import (
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// kalman is a constant velocity Kalman filter over (x, y, vx, vy).
+type kalman struct {
+	x mgl64.Vec4
+	p mgl64.Mat4
+}
+
+func newKalman(pos mgl64.Vec2, posVar, velVar float64) kalman {
+	return kalman{
+		x: mgl64.Vec4{pos[0], pos[1], 0, 0},
+		p: mgl64.Diag4(mgl64.Vec4{posVar, posVar, velVar, velVar}),
+	}
+}
+
+// predict advances the state by dt seconds with white acceleration noise of
+// spectral density q.
+func (k *kalman) predict(dt, q float64) {
+	f := mgl64.Ident4()
+	f.Set(0, 2, dt)
+	f.Set(1, 3, dt)
+	dt2, dt3 := dt*dt/2.0, dt*dt*dt/3.0
+	var noise mgl64.Mat4
+	for a := 0; a < 2; a++ {
+		noise.Set(a, a, dt3*q)
+		noise.Set(a, a+2, dt2*q)
+		noise.Set(a+2, a, dt2*q)
+		noise.Set(a+2, a+2, dt*q)
+	}
+	k.x = f.Mul4x1(k.x)
+	k.p = f.Mul4(k.p).Mul4(f.Transpose()).Add(noise)
+}
+
+// innovation returns the measurement residual and its covariance.
+func (k *kalman) innovation(z mgl64.Vec2, r float64) (mgl64.Vec2, mgl64.Mat2) {
+	y := mgl64.Vec2{z[0] - k.x[0], z[1] - k.x[1]}
+	s := mgl64.Mat2{k.p.At(0, 0) + r, k.p.At(1, 0), k.p.At(0, 1), k.p.At(1, 1) + r}
+	return y, s
+}
+
+// mahalanobis returns the squared Mahalanobis distance of a measurement.
+func (k *kalman) mahalanobis(z mgl64.Vec2, r float64) float64 {
+	y, s := k.innovation(z, r)
+	return y.Dot(s.Inv().Mul2x1(y))
+}
+
+// update corrects the state with a position measurement of variance r.
+func (k *kalman) update(z mgl64.Vec2, r float64) {
+	y, s := k.innovation(z, r)
+	si := s.Inv()
+	// gain K = P H^T S^-1, with H selecting the position
+	var gain [4]mgl64.Vec2
+	for row := 0; row < 4; row++ {
+		ph := mgl64.Vec2{k.p.At(row, 0), k.p.At(row, 1)}
+		gain[row] = mgl64.Vec2{ph.Dot(si.Col(0)), ph.Dot(si.Col(1))}
+	}
+	for row := 0; row < 4; row++ {
+		k.x[row] += gain[row].Dot(y)
+	}
+	// P = (I - K H) P
+	var p mgl64.Mat4
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			v := k.p.At(row, col) - gain[row][0]*k.p.At(0, col) - gain[row][1]*k.p.At(1, col)
+			p.Set(row, col, v)
+		}
+	}
+	k.p = p
+}
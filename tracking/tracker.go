@@ -0,0 +1,259 @@
+// Package tracking follows moving objects across scans. Clusters from the
+// segment package are associated with existing tracks and each track runs
+// a constant velocity Kalman filter.
+package tracking
+
+import (
+	"math"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/segment"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+// Association selects how clusters are assigned to tracks.
+type Association int
+
+const (
+	// Hungarian finds the assignment with the lowest total distance.
+	Hungarian Association = iota
+	// NearestNeighbor greedily assigns the closest pairs first.
+	NearestNeighbor
+)
+
+// State is the lifecycle state of a track.
+type State int
+
+const (
+	// Tentative tracks have not been seen often enough to be reported.
+	Tentative State = iota
+	// Confirmed tracks have been seen in ConfirmHits scans.
+	Confirmed
+	// Deleted tracks have been missed for more than MaxMisses scans.
+	Deleted
+)
+
+func (s State) String() string {
+	switch s {
+	case Tentative:
+		return "tentative"
+	case Confirmed:
+		return "confirmed"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Config holds the parameters of the tracker.
+type Config struct {
+	Association Association
+	// Gate is the largest squared Mahalanobis distance of an association.
+	Gate float64
+	// MaxDistance is the largest distance in millimeters of an association.
+	MaxDistance float64
+	// ProcessNoise is the acceleration noise in mm^2/s^3.
+	ProcessNoise float64
+	// MeasurementNoise is the standard deviation of a centroid in millimeters.
+	MeasurementNoise float64
+	// InitialVelocity is the standard deviation of the velocity of new
+	// tracks in mm/s.
+	InitialVelocity float64
+	// ConfirmHits is the number of hits needed to confirm a track.
+	ConfirmHits int
+	// MaxMisses is the number of consecutive misses after which a track
+	// is deleted.
+	MaxMisses int
+}
+
+// DefaultConfig returns parameters suited for people and forklifts.
+func DefaultConfig() Config {
+	return Config{
+		Association:      Hungarian,
+		Gate:             13.8, // 99.9% of a chi-square with two degrees of freedom
+		MaxDistance:      1000.0,
+		ProcessNoise:     1e6,
+		MeasurementNoise: 50.0,
+		InitialVelocity:  1500.0,
+		ConfirmHits:      3,
+		MaxMisses:        5,
+	}
+}
+
+// Track is the state of a tracked object.
+type Track struct {
+	ID         int
+	State      State
+	Position   mgl64.Vec2 // millimeters
+	Velocity   mgl64.Vec2 // millimeters per second
+	Covariance mgl64.Mat4 // of (x, y, vx, vy)
+	Hits       int        // scans in which the track was associated
+	Misses     int        // consecutive scans without an association
+	Cluster    *segment.Cluster
+
+	filter kalman
+}
+
+// EventType describes a change in the lifecycle of a track.
+type EventType int
+
+const (
+	// TrackCreated is emitted for a cluster that matched no track.
+	TrackCreated EventType = iota
+	// TrackConfirmed is emitted once a track reaches ConfirmHits, right
+	// after TrackCreated if a single hit confirms it.
+	TrackConfirmed
+	// TrackDeleted is emitted when a track has been missed too often.
+	TrackDeleted
+)
+
+func (e EventType) String() string {
+	switch e {
+	case TrackCreated:
+		return "created"
+	case TrackConfirmed:
+		return "confirmed"
+	case TrackDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Event reports a lifecycle change of a track.
+type Event struct {
+	Type  EventType
+	Track Track
+}
+
+// Tracker associates clusters across scans.
+type Tracker struct {
+	config        Config
+	tracks        []*Track
+	nextID        int
+	lastTimestamp int
+	started       bool
+}
+
+// NewTracker creates a tracker without tracks.
+func NewTracker(config Config) *Tracker {
+	return &Tracker{config: config, nextID: 1}
+}
+
+// Update advances the tracks to the timestamp of a scan, in milliseconds as
+// reported by the sensor, and associates the clusters found in it. It
+// returns the lifecycle events caused by the scan.
+func (t *Tracker) Update(clusters []segment.Cluster, timestamp int) []Event {
+	dt := 0.0
+	if t.started {
+		dt = float64(timestamp-t.lastTimestamp) / 1000.0
+		if dt < 0 {
+			// the 24 bit sensor clock wrapped around
+			dt += float64(1<<24) / 1000.0
+		}
+	}
+	t.started = true
+	t.lastTimestamp = timestamp
+
+	for _, tr := range t.tracks {
+		tr.filter.predict(dt, t.config.ProcessNoise)
+	}
+
+	r := t.config.MeasurementNoise * t.config.MeasurementNoise
+	cost := make([][]float64, len(t.tracks))
+	for i, tr := range t.tracks {
+		cost[i] = make([]float64, len(clusters))
+		for j, c := range clusters {
+			d := tr.filter.mahalanobis(c.Centroid, r)
+			dist := c.Centroid.Sub(mgl64.Vec2{tr.filter.x[0], tr.filter.x[1]}).Len()
+			if d > t.config.Gate || dist > t.config.MaxDistance {
+				cost[i][j] = math.Inf(1)
+			} else {
+				cost[i][j] = d
+			}
+		}
+	}
+	var assignment []int
+	if t.config.Association == NearestNeighbor {
+		assignment = nearestNeighbor(cost, len(t.tracks), len(clusters))
+	} else {
+		assignment = hungarian(cost, len(t.tracks), len(clusters))
+	}
+
+	events := []Event{}
+	used := make([]bool, len(clusters))
+	alive := []*Track{}
+	for i, tr := range t.tracks {
+		if j := assignment[i]; j >= 0 {
+			used[j] = true
+			tr.filter.update(clusters[j].Centroid, r)
+			tr.Hits++
+			tr.Misses = 0
+			tr.Cluster = &clusters[j]
+			if tr.State == Tentative && tr.Hits >= t.config.ConfirmHits {
+				tr.State = Confirmed
+				tr.sync()
+				events = append(events, Event{TrackConfirmed, *tr})
+			}
+		} else {
+			tr.Misses++
+			tr.Cluster = nil
+			if tr.Misses > t.config.MaxMisses || (tr.State == Tentative && tr.Misses > 1) {
+				tr.State = Deleted
+				tr.sync()
+				events = append(events, Event{TrackDeleted, *tr})
+				continue
+			}
+		}
+		tr.sync()
+		alive = append(alive, tr)
+	}
+
+	for j := range clusters {
+		if used[j] {
+			continue
+		}
+		v := t.config.InitialVelocity
+		tr := &Track{
+			ID:      t.nextID,
+			State:   Tentative,
+			Hits:    1,
+			Cluster: &clusters[j],
+			filter:  newKalman(clusters[j].Centroid, r, v*v),
+		}
+		t.nextID++
+		tr.sync()
+		alive = append(alive, tr)
+		events = append(events, Event{TrackCreated, *tr})
+		if tr.Hits >= t.config.ConfirmHits {
+			tr.State = Confirmed
+			events = append(events, Event{TrackConfirmed, *tr})
+		}
+	}
+	t.tracks = alive
+	return events
+}
+
+func (tr *Track) sync() {
+	tr.Position = mgl64.Vec2{tr.filter.x[0], tr.filter.x[1]}
+	tr.Velocity = mgl64.Vec2{tr.filter.x[2], tr.filter.x[3]}
+	tr.Covariance = tr.filter.p
+}
+
+// Tracks returns a copy of the live tracks, including tentative ones.
+func (t *Tracker) Tracks() []Track {
+	out := make([]Track, len(t.tracks))
+	for i, tr := range t.tracks {
+		out[i] = *tr
+	}
+	return out
+}
+
+// Confirmed returns a copy of the confirmed tracks.
+func (t *Tracker) Confirmed() []Track {
+	out := []Track{}
+	for _, tr := range t.tracks {
+		if tr.State == Confirmed {
+			out = append(out, *tr)
+		}
+	}
+	return out
+}
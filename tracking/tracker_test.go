@@ -0,0 +1,94 @@
+package tracking
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Dolphindalt/GoHokuyoLidar/segment"
+	"github.com/go-gl/mathgl/mgl64"
+)
+
+func TestHungarian(t *testing.T) {
+	inf := math.Inf(1)
+	cost := [][]float64{
+		{4, 1, 3},
+		{2, 0, 5},
+		{3, 2, inf},
+	}
+	a := hungarian(cost, 3, 3)
+	total := 0.0
+	for i, j := range a {
+		if j >= 0 {
+			total += cost[i][j]
+		}
+	}
+	if total != 6 {
+		t.Fatalf("Expected a total cost of 6, got %v with %v\n", total, a)
+	}
+
+	a = hungarian([][]float64{{inf, inf}}, 1, 2)
+	if a[0] != -1 {
+		t.Fatalf("Expected no assignment for a gated out row, got %v\n", a)
+	}
+}
+
+func TestTrackerFollowsTwoObjects(t *testing.T) {
+	tr := NewTracker(DefaultConfig())
+	var events []Event
+	for k := 0; k < 20; k++ {
+		ts := k * 100
+		a := mgl64.Vec2{1000 + float64(k)*100, 0}    // 1 m/s along x
+		b := mgl64.Vec2{-1000, 2000 - float64(k)*50} // 0.5 m/s along -y
+		events = append(events, tr.Update([]segment.Cluster{{Centroid: a}, {Centroid: b}}, ts)...)
+	}
+	confirmed := tr.Confirmed()
+	if len(confirmed) != 2 {
+		t.Fatalf("Expected 2 confirmed tracks, got %v\n", len(confirmed))
+	}
+	for _, c := range confirmed {
+		var want mgl64.Vec2
+		if c.ID == 1 {
+			want = mgl64.Vec2{1000, 0}
+		} else {
+			want = mgl64.Vec2{0, -500}
+		}
+		if c.Velocity.Sub(want).Len() > 50 {
+			t.Fatalf("Expected track %v velocity %v, got %v\n", c.ID, want, c.Velocity)
+		}
+	}
+
+	created, confirmedEvents := 0, 0
+	for _, e := range events {
+		if e.Type == TrackCreated {
+			created++
+		} else if e.Type == TrackConfirmed {
+			confirmedEvents++
+		}
+	}
+	if created != 2 || confirmedEvents != 2 {
+		t.Fatalf("Expected 2 created and 2 confirmed events, got %v and %v\n", created, confirmedEvents)
+	}
+
+	for k := 20; k < 30; k++ {
+		tr.Update(nil, k*100)
+	}
+	if len(tr.Tracks()) != 0 {
+		t.Fatalf("Expected all tracks to be deleted, got %v\n", tr.Tracks())
+	}
+}
+
+func TestConfirmOnCreation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConfirmHits = 1
+	tr := NewTracker(cfg)
+	events := tr.Update([]segment.Cluster{{Centroid: mgl64.Vec2{1000, 0}}}, 0)
+	if len(events) != 2 || events[0].Type != TrackCreated || events[1].Type != TrackConfirmed {
+		t.Fatalf("Expected a created and a confirmed event, got %v\n", events)
+	}
+	if events[0].Track.State != Tentative || events[1].Track.State != Confirmed || len(tr.Confirmed()) != 1 {
+		t.Fatalf("Expected the track to be confirmed on creation, got %v\n", events)
+	}
+	if events := tr.Update([]segment.Cluster{{Centroid: mgl64.Vec2{1050, 0}}}, 100); len(events) != 0 {
+		t.Fatalf("Expected no further event, got %v\n", events)
+	}
+}